@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestBatch tests 16x16, 64x64 and 512x512 images on 0, 1 and 100 turns with worker nodes evaluating 4 and 16 turns per RPC.
+func TestBatch(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+		{ImageWidth: 512, ImageHeight: 512},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			expectedAlive := readAliveCells(
+				"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			for _, batch := range []int{4, 16} {
+				p.Batch = batch
+				for _, threads := range []int{1, 2, 4, 8, 12, 16} {
+					p.Threads = threads
+					testName := fmt.Sprintf("%dx%dx%d-%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads, p.Batch)
+					t.Run(testName, func(t *testing.T) {
+						events := make(chan gol.Event)
+						go gol.Run(p, events, nil)
+						var cells []util.Cell
+						for event := range events {
+							switch e := event.(type) {
+							case gol.FinalTurnComplete:
+								cells = e.Alive
+							}
+						}
+						assertEqualBoard(t, cells, expectedAlive, p)
+					})
+				}
+			}
+		}
+	}
+}
@@ -27,15 +27,13 @@ func (w null_writer) Write(p []byte) (n int, err error) {
 // Initialise a new broker and return connection to it
 func initBroker() (*Broker, *net.TCPConn) {
 
-	broker := &Broker{
-		cond: sync.NewCond(new(sync.Mutex)),
-	}
+	broker := NewBroker(nil)
 	mutex := new(sync.Mutex)
 	listener, _ := net.ListenTCP("tcp", &net.TCPAddr{Port: 2001})
 	mutex.Lock()
 	go func() {
 		conn, _ := listener.Accept()
-		broker.local_conn = &Connection{
+		broker.pending[1] = &Connection{
 			conn:  conn.(*net.TCPConn),
 			mutex: new(sync.Mutex),
 		}
@@ -119,10 +117,12 @@ func Benchmark_512_1000(b *testing.B) {
 			copy(copied, compressed)
 
 			bp := BrokerParams{
+				Connection:  1,
 				Turns:       1000,
 				Threads:     threads,
 				ImageWidth:  512,
 				ImageHeight: 512,
+				Rule:        Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3},
 				Pixels:      copied,
 				SizeInt:     2,
 			}
@@ -135,7 +135,7 @@ func Benchmark_512_1000(b *testing.B) {
 
 				for i := 0; i < b.N; i++ {
 					broker, read_conn := initBroker()
-					broker.Init(bp, &struct{}{})
+					broker.Init(bp, new(string))
 					b.ResetTimer()
 					readAllTurns(read_conn, 1000)
 				}
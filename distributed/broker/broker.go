@@ -1,29 +1,79 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
 	"sync"
+	"time"
 )
 
+// Time to wait for a running session to accept an attaching local controller
+const attachTimeout = time.Second * 10
+
+// Time a connection of local controller waits to be claimed by Init or Attach before it is closed
+const claimTimeout = time.Minute
+
 func main() {
 
+	// Load configuration
+	config_flags := RegisterConfigFlags(flag.CommandLine)
+	state_dir := flag.String(
+		"state-dir",
+		"broker-state",
+		"Specify the directory keeping durable state of running sessions. Empty to disable.")
+	state_interval := flag.Int(
+		"state-interval",
+		100,
+		"Specify the number of turns between snapshots of durable state. Defaults to 100.")
+	rpc_timeout := flag.Duration(
+		"rpc-timeout",
+		defaultRPCTimeout,
+		"Specify the time a worker node may take to reply before it is declared failed. Defaults to 30s.")
+	heartbeat_timeout := flag.Duration(
+		"heartbeat-timeout",
+		defaultHeartbeatTimeout,
+		"Specify the time without heartbeats after which a worker node is declared failed. Defaults to 5s.")
+	recovery_wait := flag.Duration(
+		"recovery-wait",
+		defaultRecoveryWait,
+		"Specify the time a session waits for worker nodes to reappear when all failed. Defaults to 30s.")
+	recovery_attempts := flag.Int(
+		"recovery-attempts",
+		defaultRecoveryAttempts,
+		"Specify the number of attempts of dispatching a session to worker nodes before it stops. Defaults to 5.")
+	flag.Parse()
+	config, err := config_flags.Load()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	// Create broker singleton
-	broker := &Broker{
-		cond: sync.NewCond(new(sync.Mutex)),
-		flag: sync.WaitGroup{},
+	broker := NewBroker(NewStateStore(*state_dir, *state_interval))
+	broker.rpc_timeout = *rpc_timeout
+	broker.recovery_wait = *recovery_wait
+	broker.recovery_attempts = *recovery_attempts
+
+	// Report durable state left by previous broker process
+	for _, session := range broker.store.list() {
+		if bp, err := broker.store.load(session); err == nil {
+			log.Printf("Durable state found: session %s %dx%dx%d-%d (at %d)",
+				session, bp.ImageWidth, bp.ImageHeight, bp.Turns, bp.Threads, bp.Turn)
+		}
 	}
-	broker.flag.Add(1)
 
 	// Register RPC service
 	rpc.Register(broker)
 	rpc.HandleHTTP()
 
 	// Start RPC handling service
-	listener, err := net.Listen("tcp", ":2000")
+	listener, err := net.Listen("tcp", listenAddr(config.RPCPort))
 	if err != nil {
 		log.Panic(err.Error())
 	}
@@ -31,7 +81,7 @@ func main() {
 
 	// Accept connection request from local controller
 	go func() {
-		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: 2001})
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.StreamPort})
 		if err != nil {
 			log.Panic(err.Error())
 		}
@@ -40,308 +90,405 @@ func main() {
 			if err != nil {
 				log.Panic(err.Error())
 			}
-			broker.cond.L.Lock()
-			broker.local_conn = &Connection{conn: conn, mutex: new(sync.Mutex)}
+			id := broker.addPending(conn)
+			time.AfterFunc(claimTimeout, func() {
+				if expired := broker.claim(id); expired != nil {
+					log.Printf("Connection to %s closed: not claimed", expired.conn.RemoteAddr().String())
+					expired.conn.Close()
+				}
+			})
+
+			// Report ID of connection so that local controller can claim it in Init or Attach
+			var id_bytes [8]byte
+			binary.LittleEndian.PutUint64(id_bytes[:], id)
+			if _, err = conn.Write(id_bytes[:]); err != nil {
+				broker.claim(id)
+				conn.Close()
+				continue
+			}
 			log.Printf("Connection to %s established", conn.RemoteAddr().String())
-			broker.cond.Signal()
-			broker.cond.L.Unlock()
 		}
 	}()
 
 	// Accepting connection requests from worker nodes and monitor their status
-	go monitorNodes()
+	go monitorNodes(config, broker.scheduler, *heartbeat_timeout)
 
+	// Wait for all sessions stopping after shutdown
 	broker.flag.Wait()
-	broker.cond.L.Lock()
-	broker.cond.L.Unlock()
+	broker.mutex.Lock()
+	for len(broker.sessions) != 0 {
+		broker.cond.Wait()
+	}
+	broker.mutex.Unlock()
 
 	shutdownNodes()
 }
 
 type Broker struct {
-	cond           *sync.Cond
-	local_conn     *Connection
-	event_chan     chan byte
-	bp             BrokerParams
-	turn           int
-	matrix         Matrix
-	exchange_graph [][]byte
+	mutex     *sync.Mutex            // Synchronise access to sessions and pending connections
+	cond      *sync.Cond             // Signalled when a session ends
+	sessions  map[string]*Session    // Running sessions by ID
+	pending   map[uint64]*Connection // Connections not yet claimed by Init or Attach (by random ID)
+	scheduler *Scheduler             // Sharing worker nodes between sessions
+	store     *StateStore            // Durable state for continuing sessions after broker restarts
+	shutdown  bool                   // No more sessions accepted
+
+	rpc_timeout       time.Duration // Time worker nodes may take to reply before declared failed
+	recovery_wait     time.Duration // Time a recovering session waits for worker nodes to reappear
+	recovery_attempts int           // Number of attempts of dispatching before a session stops
 
 	flag sync.WaitGroup
 }
 
-func (broker *Broker) Init(bp BrokerParams, reply *struct{}) error {
-
-	log.Printf("Init: %dx%dx%d-%d", bp.ImageWidth, bp.ImageWidth, bp.Turns, bp.Threads)
+// Default timeouts of detecting failed worker nodes and recovering from them
+const (
+	defaultRPCTimeout       = time.Second * 30
+	defaultHeartbeatTimeout = time.Second * 5
+	defaultRecoveryWait     = time.Second * 30
+	defaultRecoveryAttempts = 5
+)
 
-	// Wait for connection
-	broker.cond.L.Lock()
-	if broker.local_conn == nil {
-		broker.cond.Wait()
+func NewBroker(store *StateStore) *Broker {
+	broker := &Broker{
+		mutex:     new(sync.Mutex),
+		sessions:  make(map[string]*Session),
+		pending:   make(map[uint64]*Connection),
+		scheduler: NewScheduler(),
+		store:     store,
+
+		rpc_timeout:       defaultRPCTimeout,
+		recovery_wait:     defaultRecoveryWait,
+		recovery_attempts: defaultRecoveryAttempts,
 	}
+	broker.cond = sync.NewCond(broker.mutex)
+	broker.flag.Add(1)
+	return broker
+}
 
-	// Reset broker instance status
-	broker.bp = bp
-	broker.turn = 0
-	broker.event_chan = make(chan byte, 1)
+// Start a new session and reply its ID
+// Session ID is kept if given in parameters (continuing a session from durable state)
+func (broker *Broker) Init(bp BrokerParams, reply *string) error {
+
+	log.Printf("Init: %dx%dx%d-%d", bp.ImageWidth, bp.ImageHeight, bp.Turns, bp.Threads)
+
+	// Claim connection for data streaming
+	local_conn := broker.claim(bp.Connection)
+	if local_conn == nil {
+		return errors.New("unknown connection")
+	}
 
-	// Partitioning
-	nodes := getAvailableNodes()
-	if len(nodes) == 0 {
-		return errors.New("no worker nodes available")
+	// Register session (continued from durable state may have been continued by another controller)
+	session, err := broker.register(bp)
+	if err != nil {
+		local_conn.conn.Close()
+		return err
 	}
-	blocks := divideToBlocks(bp)
-	assignments := partitioning(nodes, blocks)
-	broker.exchange_graph = getExchangeGraph(bp.ImageWidth, bp.ImageHeight, assignments)
+	session.conns = []*Connection{local_conn}
 
 	// Decompress pixel data
 	pixels, surrounding_counts := decompressMatrix(&bp)
-	broker.matrix = MakeMatrixFromData(pixels, surrounding_counts)
-
-	// Dispatch matrix data
-	call_chan := make(chan *rpc.Call, len(assignments))
-	defer close(call_chan)
-	for _, assignment := range assignments {
-		// Transmit rows in partition only
-		pixels_in_partition := make([][]uint8, broker.bp.ImageHeight)
-		surrounding_counts_in_partition := make([][]int8, broker.bp.ImageHeight)
-		for _, block := range assignment.Partition {
-			for y := block.Start.Y; y != block.End.Y; y++ {
-				if pixels_in_partition[y] == nil {
-					pixels_in_partition[y] = pixels[y]
-					surrounding_counts_in_partition[y] = surrounding_counts[y]
-				}
-			}
-		}
-		wp := WorkerParams{
-			Turns:             bp.Turns,
-			Threads:           bp.Threads,
-			ImageWidth:        bp.ImageWidth,
-			ImageHeight:       bp.ImageHeight,
-			Pixels:            pixels_in_partition,
-			SurroundingCounts: surrounding_counts_in_partition,
-			Partition:         assignment.Partition,
-			SizeInt:           bp.SizeInt,
-		}
-		var reply struct{}
-		assignment.Node.client.Go("Worker.Init", wp, &reply, call_chan)
-	}
-
-	// Check if all RPC calls succeeded
-	for range assignments {
-		call := <-call_chan
-		if call.Error != nil {
-			saved_local_conn := broker.local_conn
-			broker.local_conn = nil
-			broker.cond.L.Unlock()
-			return broker.recover_evaluation(saved_local_conn)
-		}
+	session.matrix = MakeMatrixFromData(pixels, surrounding_counts, bp.Topology)
+
+	// Allocate worker nodes and dispatch matrix data
+	assignments, err := session.start(0, allocateTimeout)
+	if err != nil {
+		local_conn.conn.Close()
+		broker.end(session)
+		return err
 	}
+	broker.store.save(session.bp, &session.matrix, session.turn)
+	log.Printf("Session %s started: %d worker nodes", session.id, len(session.nodes))
+	*reply = session.id
 
 	// Create loop goroutine
-	go broker.loop(assignments)
+	go session.loop(assignments)
 
 	return nil
 }
 
-func (broker *Broker) Resume(struct{}, *struct{}) error {
+// Get the last durable state of an interrupted session (most recent one not running if session is empty)
+// Local controller continues the session by calling Init with the state returned
+func (broker *Broker) Restore(args SessionArgs, reply *BrokerParams) error {
 
-	log.Print("Resume")
-	broker.event_chan <- EVENT_RESUME
-	broker.cond.Broadcast()
+	log.Printf("Restore: %s", args.Session)
+	session := args.Session
+	if session != "" && !validSessionID(session) {
+		return errors.New("invalid session ID")
+	}
+	if session == "" {
+		sessions := broker.store.list()
+		for i := len(sessions) - 1; i >= 0; i-- {
+			if _, err := broker.lookup(sessions[i]); err != nil {
+				session = sessions[i]
+				break
+			}
+		}
+		if session == "" {
+			return errors.New("no durable state available")
+		}
+	} else if _, err := broker.lookup(session); err == nil {
+		return errors.New("session " + session + " already running")
+	}
+	bp, err := broker.store.load(session)
+	if err != nil {
+		return err
+	}
+	*reply = bp
 	return nil
 }
 
-func (broker *Broker) Pause(struct{}, *struct{}) error {
+// Attach connection of local controller to running session and reply current state of matrix
+// Flipped cells of following turns are streamed to the connection
+func (broker *Broker) Attach(args SessionArgs, reply *BrokerParams) error {
 
-	log.Print("Pause")
-	broker.event_chan <- EVENT_PAUSE
+	log.Printf("Attach: %s", args.Session)
+	session, err := broker.lookup(args.Session)
+	if err != nil {
+		return err
+	}
+	request := AttachRequest{reply: make(chan *BrokerParams, 1)}
+	if args.Connection != 0 {
+		request.conn = broker.claim(args.Connection)
+		if request.conn == nil {
+			return errors.New("unknown connection")
+		}
+	}
+
+	// Session replies at the end of current turn
+	select {
+	case session.attach_chan <- request:
+	case <-session.done:
+		err = errors.New("session " + args.Session + " not running")
+	case <-time.After(attachTimeout):
+		err = errors.New("session " + args.Session + " not responding")
+	}
+	if err != nil {
+		if request.conn != nil {
+			request.conn.conn.Close()
+		}
+		return err
+	}
+	*reply = *<-request.reply
 	return nil
 }
 
-func (broker *Broker) Save(struct{}, *struct{}) error {
+// Set cells of a region of running session at the end of current turn
+// Flipped cells are streamed to attached local controllers and worker nodes continue from the new state
+func (broker *Broker) Edit(args EditArgs, _ *struct{}) error {
 
-	log.Print("Save")
-	broker.event_chan <- EVENT_SAVE
-	return nil
+	log.Printf("Edit: %s (%d,%d)-(%d,%d) alive %v", args.Session,
+		args.Start.X, args.Start.Y, args.End.X, args.End.Y, args.Alive)
+	session, err := broker.lookup(args.Session)
+	if err != nil {
+		return err
+	}
+	request := EditRequest{args: args, reply: make(chan error, 1)}
+
+	// Session replies at the end of current turn
+	select {
+	case session.edit_chan <- request:
+	case <-session.done:
+		return errors.New("session " + args.Session + " not running")
+	case <-time.After(attachTimeout):
+		return errors.New("session " + args.Session + " not responding")
+	}
+	return <-request.reply
 }
 
-func (broker *Broker) Quit(struct{}, *struct{}) error {
+// Evaluate given number of turns of a session and hold it after them, replying the turn it is held at
+// Turns requested while a session is held are added to those left
+func (broker *Broker) Step(args StepArgs, reply *int) error {
 
-	log.Print("Quit")
-	broker.event_chan <- EVENT_QUIT
+	log.Printf("Step: %s %d turns", args.Session, args.Turns)
+	session, err := broker.lookup(args.Session)
+	if err != nil {
+		return err
+	}
+	request := StepRequest{turns: args.Turns, reply: make(chan int, 1)}
+
+	// Session replies at the end of current turn
+	select {
+	case session.step_chan <- request:
+	case <-session.done:
+		return errors.New("session " + args.Session + " not running")
+	case <-time.After(attachTimeout):
+		return errors.New("session " + args.Session + " not responding")
+	}
+	*reply = <-request.reply
 	return nil
 }
 
-func (broker *Broker) Kill(struct{}, *struct{}) error {
+func (broker *Broker) Resume(args SessionArgs, _ *struct{}) error {
 
-	log.Print("Kill")
-	broker.event_chan <- EVENT_KILL
-	return nil
+	log.Printf("Resume: %s", args.Session)
+	return broker.send(args.Session, EVENT_RESUME)
 }
 
-func (broker *Broker) loop(assignments []AssignedPartition) {
+func (broker *Broker) Pause(args SessionArgs, _ *struct{}) error {
 
-	defer broker.cond.L.Unlock()
-	defer func() {
-		if broker.local_conn != nil {
-			broker.local_conn.conn.Close()
-			log.Print("Connection closed: " + broker.local_conn.conn.RemoteAddr().String())
-			broker.local_conn = nil
-		}
-	}()
-	defer close(broker.event_chan)
-	defer func() { recover() }()
-
-	// Create buffer for storing RPC results
-	call_buffer := make([]*rpc.Call, len(assignments))
-
-	// Create buffers for adjustment
-	adjustment_buffers := make([]Adjustment, len(assignments))
-	for i := 0; i != len(assignments); i++ {
-		adjustment_buffers[i] = Adjustment{
-			Increment: make([]Cell, 0, 1024),
-			Decrement: make([]Cell, 0, 1024),
-		}
+	log.Printf("Pause: %s", args.Session)
+	return broker.send(args.Session, EVENT_PAUSE)
+}
+
+// Send state of current turn to all local controllers attached to a session, tagged with the requester
+func (broker *Broker) Save(args SaveArgs, _ *struct{}) error {
+
+	log.Printf("Save: %s (requester %d)", args.Session, args.Requester)
+	session, err := broker.lookup(args.Session)
+	if err != nil {
+		return err
 	}
+	return session.save(args.Requester)
+}
 
-	// Channels for asynchorous call
-	call_chan := make(chan *rpc.Call, len(assignments))
-	defer close(call_chan)
+func (broker *Broker) Quit(args SessionArgs, _ *struct{}) error {
 
-	// Evaluate all turns
-	pause_flag := false
-	for ; broker.turn != broker.bp.Turns; broker.turn++ {
+	log.Printf("Quit: %s", args.Session)
+	return broker.send(args.Session, EVENT_QUIT)
+}
 
-		// Instruct worker nodes to evaluate next turn
-		for i, assignment := range assignments {
-			var flipped []byte
-			assignment.Node.client.Go("Worker.Next", adjustment_buffers[i], &flipped, call_chan)
-		}
+// Stop a session without affecting other sessions
+func (broker *Broker) Kill(args SessionArgs, _ *struct{}) error {
 
-		// Clear adjustment buffers
-		for i := range assignments {
-			adjustment_buffers[i].Increment = adjustment_buffers[i].Increment[0:0]
-			adjustment_buffers[i].Decrement = adjustment_buffers[i].Decrement[0:0]
-		}
+	log.Printf("Kill: %s", args.Session)
+	return broker.send(args.Session, EVENT_KILL)
+}
 
-		// Check if all RPC calls succeeded
-		successful := true
-		for i := range assignments {
-			call := <-call_chan
-			if call.Error != nil {
-				log.Print(call.Error.Error())
-				successful = false
-			}
-			call_buffer[i] = call
-		}
+// Stop all sessions and shut down broker and worker nodes (administration of the whole system)
+func (broker *Broker) Shutdown(_ struct{}, _ *struct{}) error {
 
-		if !successful {
-			// Recover task when any worker nodes getting offline
-			saved_local_conn := broker.local_conn
-			broker.local_conn = nil
-			go broker.recover_evaluation(saved_local_conn)
-			return
-		}
+	log.Print("Shutdown")
+	broker.mutex.Lock()
+	shutdown := broker.shutdown
+	broker.shutdown = true
+	sessions := make([]*Session, 0, len(broker.sessions))
+	for _, session := range broker.sessions {
+		sessions = append(sessions, session)
+	}
+	broker.mutex.Unlock()
 
-		// Apply flipping results
-		for i := range assignments {
-			flipped_data := *call_buffer[i].Reply.(*[]byte)
-			flipped := decompressFlipped(flipped_data, broker.bp.SizeInt)
-			broker.local_conn.writeCompressedFlipped(flipped_data)
-			broker.updateMatrixAndGetAdjustments(flipped, adjustment_buffers)
-		}
-		broker.local_conn.writeEvent(EVENT_TURN_COMPLETE)
+	for _, session := range sessions {
+		session.send(EVENT_KILL)
+	}
+	if !shutdown {
+		broker.flag.Done()
+	}
+	return nil
+}
 
-		// Handle events from local controller
-		for {
-			select {
-			case event := <-broker.event_chan:
-				broker.local_conn.writeEvent(event)
-				switch event {
-				case EVENT_PAUSE:
-					pause_flag = true
-				case EVENT_RESUME:
-					pause_flag = false
-				case EVENT_QUIT:
-					return
-				case EVENT_KILL:
-					broker.flag.Done()
-					return
-				}
-			default:
-			}
-			if !pause_flag {
-				break
-			}
-		}
+// Send event to running session
+func (broker *Broker) send(id string, event byte) error {
+	session, err := broker.lookup(id)
+	if err != nil {
+		return err
 	}
+	return session.send(event)
 }
 
-// Recover evaluation task from unexpected faliure of RPC to worker
-func (broker *Broker) recover_evaluation(saved_local_conn *Connection) error {
-
-	broker.cond.L.Lock()
-
-	log.Printf("Recover: %dx%dx%d-%d (from %d)", broker.bp.ImageWidth, broker.bp.ImageWidth,
-		broker.bp.Turns, broker.bp.Threads, broker.turn)
-
-	// Reset broker status
-	bp := broker.bp
-	broker.event_chan = make(chan byte, 1)
-	broker.local_conn = saved_local_conn
-
-	// Partitioning
-	nodes := getAvailableNodes()
-	if len(nodes) == 0 {
-		return errors.New("no worker nodes available")
-	}
-	blocks := divideToBlocks(bp)
-	assignments := partitioning(nodes, blocks)
-	broker.exchange_graph = getExchangeGraph(bp.ImageWidth, bp.ImageHeight, assignments)
-
-	// Dispatch matrix data
-	call_chan := make(chan *rpc.Call, len(assignments))
-	defer close(call_chan)
-	for _, assignment := range assignments {
-		// Transmit rows in partition only
-		pixels_in_partition := make([][]uint8, bp.ImageHeight)
-		surrounding_counts_in_partition := make([][]int8, bp.ImageHeight)
-		for _, block := range assignment.Partition {
-			for y := block.Start.Y; y != block.End.Y; y++ {
-				if pixels_in_partition[y] == nil {
-					pixels_in_partition[y] = broker.matrix.pixels[y]
-					surrounding_counts_in_partition[y] = broker.matrix.surrounding_counts[y]
-				}
-			}
-		}
-		wp := WorkerParams{
-			Turns:             bp.Turns,
-			Threads:           bp.Threads,
-			ImageWidth:        bp.ImageWidth,
-			ImageHeight:       bp.ImageHeight,
-			Pixels:            pixels_in_partition,
-			SurroundingCounts: surrounding_counts_in_partition,
-			Partition:         assignment.Partition,
-			SizeInt:           bp.SizeInt,
+// Create and register a new session
+func (broker *Broker) register(bp BrokerParams) (*Session, error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.shutdown {
+		return nil, errors.New("broker shutting down")
+	}
+	if bp.Session == "" {
+		bp.Session = newSessionID()
+	} else if !validSessionID(bp.Session) {
+		return nil, errors.New("invalid session ID")
+	} else if _, ok := broker.sessions[bp.Session]; ok {
+		return nil, errors.New("session " + bp.Session + " already running")
+	}
+	session := &Session{
+		broker:      broker,
+		id:          bp.Session,
+		attach_chan: make(chan AttachRequest),
+		edit_chan:   make(chan EditRequest),
+		step_chan:   make(chan StepRequest),
+		save_chan:   make(chan uint64, 1),
+		event_chan:  make(chan byte, 1),
+		done:        make(chan struct{}),
+		bp:          bp,
+		turn:        bp.Turn,
+		synced:      bp.Turn,
+		demand:      len(divideToBlocks(bp)),
+		speeds:      make(map[Node]float64),
+	}
+	broker.sessions[session.id] = session
+	return session, nil
+}
+
+// Release worker nodes of a session and remove it
+func (broker *Broker) end(session *Session) {
+	broker.scheduler.release(session)
+	broker.mutex.Lock()
+	delete(broker.sessions, session.id)
+	close(session.done)
+	broker.cond.Broadcast()
+	broker.mutex.Unlock()
+	log.Printf("Session %s ended at turn %d", session.id, session.turn)
+}
+
+// Add connection waiting to be claimed by Init or Attach and return its ID
+// IDs are random so that a connection cannot be claimed by guessing the ID of another one
+func (broker *Broker) addPending(conn *net.TCPConn) uint64 {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for {
+		var id_bytes [8]byte
+		if _, err := rand.Read(id_bytes[:]); err != nil {
+			log.Panic(err.Error())
 		}
-		var reply struct{}
-		assignment.Node.client.Go("Worker.Init", wp, &reply, call_chan)
-	}
-
-	// Check if all RPC calls succeeded
-	for range assignments {
-		call := <-call_chan
-		if call.Error != nil {
-			saved_local_conn := broker.local_conn
-			broker.local_conn = nil
-			broker.cond.L.Unlock()
-			return broker.recover_evaluation(saved_local_conn)
+		id := binary.LittleEndian.Uint64(id_bytes[:])
+		if _, taken := broker.pending[id]; id != 0 && !taken {
+			broker.pending[id] = &Connection{conn: conn, mutex: new(sync.Mutex)}
+			return id
 		}
 	}
+}
 
-	// Create loop goroutine
-	go broker.loop(assignments)
+// Take connection waiting to be claimed by Init or Attach (nil if not found)
+func (broker *Broker) claim(id uint64) *Connection {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	conn := broker.pending[id]
+	delete(broker.pending, id)
+	return conn
+}
 
-	return nil
+// Find running session by ID
+func (broker *Broker) lookup(id string) (*Session, error) {
+	if !validSessionID(id) {
+		return nil, errors.New("invalid session ID")
+	}
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	session, ok := broker.sessions[id]
+	if !ok {
+		return nil, errors.New("unknown session " + id)
+	}
+	return session, nil
+}
+
+// Generate random session ID
+func newSessionID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		log.Panic(err.Error())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// Check that session ID is in the format generated by newSessionID (16 lowercase hex digits)
+// IDs from local controllers name state files, so any other ID is rejected before it is used
+func validSessionID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+// TestConnectionID tests that connections waiting to be claimed get distinct random IDs.
+func TestConnectionID(t *testing.T) {
+	broker := NewBroker(nil)
+	ids := make(map[uint64]struct{})
+	for i := 0; i != 100; i++ {
+		id := broker.addPending(nil)
+		if _, taken := ids[id]; taken || id == 0 {
+			t.Fatalf("Expected a new non-zero connection ID, got %v", id)
+		}
+		ids[id] = struct{}{}
+	}
+	sequential := 0
+	for id := range ids {
+		if _, ok := ids[id+1]; ok {
+			sequential++
+		}
+	}
+	if sequential > 1 {
+		t.Errorf("Expected random connection IDs, %v follow another", sequential)
+	}
+	for id := range ids {
+		if broker.claim(id) == nil || broker.claim(id) != nil {
+			t.Errorf("Expected connection %v to be claimed once", id)
+		}
+	}
+}
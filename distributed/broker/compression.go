@@ -1,18 +1,9 @@
 package main
 
-// Get positions of surrounding cells of a specific cell (identical to that of Matrix)
-func getSurrounding(width, height int, cell Cell) [8]Cell {
+// Get positions of surrounding cells of a specific cell and the number of them (identical to that of Matrix)
+func getSurrounding(width, height int, topology Topology, cell Cell) ([8]Cell, int) {
 	if cell.X == 0 || cell.Y == 0 || cell.X == width-1 || cell.Y == height-1 {
-		return [8]Cell{
-			{X: (cell.X - 1 + width) % width, Y: (cell.Y - 1 + height) % height},
-			{X: cell.X, Y: (cell.Y - 1 + height) % height},
-			{X: (cell.X + 1) % width, Y: (cell.Y - 1 + height) % height},
-			{X: (cell.X - 1 + width) % width, Y: cell.Y},
-			{X: (cell.X + 1) % width, Y: cell.Y},
-			{X: (cell.X - 1 + width) % width, Y: (cell.Y + 1) % height},
-			{X: cell.X, Y: (cell.Y + 1) % height},
-			{X: (cell.X + 1) % width, Y: (cell.Y + 1) % height},
-		}
+		return topology.surrounding(width, height, cell)
 	} else {
 		return [8]Cell{
 			{X: cell.X - 1, Y: cell.Y - 1},
@@ -23,7 +14,7 @@ func getSurrounding(width, height int, cell Cell) [8]Cell {
 			{X: cell.X - 1, Y: cell.Y + 1},
 			{X: cell.X, Y: cell.Y + 1},
 			{X: cell.X + 1, Y: cell.Y + 1},
-		}
+		}, 8
 	}
 }
 
@@ -49,7 +40,8 @@ func decompressMatrix(bp *BrokerParams) (matrix [][]uint8, surrounding_counts []
 			} else {
 				pixel_data[i] = 255
 				this_cell := Cell{X: i % bp.ImageWidth, Y: i / bp.ImageWidth}
-				for _, cell := range getSurrounding(bp.ImageWidth, bp.ImageHeight, this_cell) {
+				surroundings, n := getSurrounding(bp.ImageWidth, bp.ImageHeight, bp.Topology, this_cell)
+				for _, cell := range surroundings[:n] {
 					surrounding_counts[cell.Y][cell.X]++
 				}
 			}
@@ -65,7 +57,8 @@ func decompressMatrix(bp *BrokerParams) (matrix [][]uint8, surrounding_counts []
 				pos.Y |= int(bp.Initials[i+bp.SizeInt+j]) << (j * 8)
 			}
 			matrix[pos.Y][pos.X] = 255
-			for _, cell := range getSurrounding(bp.ImageWidth, bp.ImageHeight, Cell{X: pos.X, Y: pos.Y}) {
+			surroundings, n := getSurrounding(bp.ImageWidth, bp.ImageHeight, bp.Topology, Cell{X: pos.X, Y: pos.Y})
+			for _, cell := range surroundings[:n] {
 				surrounding_counts[cell.Y][cell.X]++
 			}
 		}
@@ -88,3 +81,20 @@ func decompressFlipped(data []byte, size_int int) []Cell {
 	}
 	return flipped
 }
+
+// Compress slice of flipped cells in the layout of cells flipped events
+func compressFlipped(flipped []Cell, size_int int) []byte {
+	data := make([]byte, len(flipped)*size_int*2)
+	dest := data
+	for _, cell := range flipped {
+		for j := 0; j != size_int; j++ {
+			dest[0] = byte(cell.X >> (j << 3))
+			dest = dest[1:]
+		}
+		for j := 0; j != size_int; j++ {
+			dest[0] = byte(cell.Y >> (j << 3))
+			dest = dest[1:]
+		}
+	}
+	return data
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Config provides the addresses and ports of the controller, broker and worker processes.
+// Every process resolves its configuration in the same order: built-in defaults,
+// an optional JSON config file, environment variables and finally command line flags.
+type Config struct {
+	BrokerHost    string `json:"broker_host"`     // Host name or IP address of broker
+	RPCPort       int    `json:"rpc_port"`        // Port of broker RPC service
+	StreamPort    int    `json:"stream_port"`     // Port of broker streaming service for local controller
+	WorkerPort    int    `json:"worker_port"`     // Port of broker accepting registrations of worker nodes
+	WorkerRPCPort int    `json:"worker_rpc_port"` // Port of worker RPC service
+}
+
+// ConfigFlags binds configuration options to a flag set
+type ConfigFlags struct {
+	flags  *flag.FlagSet
+	path   *string
+	values Config
+}
+
+// Get default configuration for running a local cluster
+func DefaultConfig() Config {
+	return Config{
+		BrokerHost:    "127.0.0.1",
+		RPCPort:       2000,
+		StreamPort:    2001,
+		WorkerPort:    2002,
+		WorkerRPCPort: 2003,
+	}
+}
+
+// Load configuration from defaults, optional config file and environment variables
+// Path of config file is taken from GOL_CONFIG when path is empty
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		path = os.Getenv("GOL_CONFIG")
+	}
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return config, err
+		}
+	}
+	err := config.loadEnv()
+	return config, err
+}
+
+// Register configuration flags on given flag set (must be called before parsing)
+func RegisterConfigFlags(flags *flag.FlagSet) *ConfigFlags {
+	defaults := DefaultConfig()
+	config_flags := &ConfigFlags{flags: flags}
+	config_flags.path = flags.String(
+		"config",
+		"",
+		"Specify a JSON config file with addresses and ports. Defaults to $GOL_CONFIG.")
+	flags.StringVar(
+		&config_flags.values.BrokerHost,
+		"broker",
+		defaults.BrokerHost,
+		"Specify the host of the broker. Defaults to "+defaults.BrokerHost+".")
+	flags.IntVar(
+		&config_flags.values.RPCPort,
+		"rpc-port",
+		defaults.RPCPort,
+		fmt.Sprintf("Specify the port of the broker RPC service. Defaults to %d.", defaults.RPCPort))
+	flags.IntVar(
+		&config_flags.values.StreamPort,
+		"stream-port",
+		defaults.StreamPort,
+		fmt.Sprintf("Specify the port of the broker streaming service. Defaults to %d.", defaults.StreamPort))
+	flags.IntVar(
+		&config_flags.values.WorkerPort,
+		"worker-port",
+		defaults.WorkerPort,
+		fmt.Sprintf("Specify the port of the broker accepting workers. Defaults to %d.", defaults.WorkerPort))
+	flags.IntVar(
+		&config_flags.values.WorkerRPCPort,
+		"worker-rpc-port",
+		defaults.WorkerRPCPort,
+		fmt.Sprintf("Specify the port of the worker RPC service. Defaults to %d.", defaults.WorkerRPCPort))
+	return config_flags
+}
+
+// Resolve configuration after flags are parsed
+// Only flags explicitly set on command line override file and environment
+func (config_flags *ConfigFlags) Load() (Config, error) {
+	config, err := LoadConfig(*config_flags.path)
+	if err != nil {
+		return config, err
+	}
+	config_flags.flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "broker":
+			config.BrokerHost = config_flags.values.BrokerHost
+		case "rpc-port":
+			config.RPCPort = config_flags.values.RPCPort
+		case "stream-port":
+			config.StreamPort = config_flags.values.StreamPort
+		case "worker-port":
+			config.WorkerPort = config_flags.values.WorkerPort
+		case "worker-rpc-port":
+			config.WorkerRPCPort = config_flags.values.WorkerRPCPort
+		}
+	})
+	return config, nil
+}
+
+// Overwrite fields present in JSON config file
+func (config *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Overwrite fields with environment variables that are set
+func (config *Config) loadEnv() error {
+	if value, ok := os.LookupEnv("GOL_BROKER_HOST"); ok {
+		config.BrokerHost = value
+	}
+	ports := []struct {
+		name string
+		port *int
+	}{
+		{"GOL_RPC_PORT", &config.RPCPort},
+		{"GOL_STREAM_PORT", &config.StreamPort},
+		{"GOL_WORKER_PORT", &config.WorkerPort},
+		{"GOL_WORKER_RPC_PORT", &config.WorkerRPCPort},
+	}
+	for _, entry := range ports {
+		if value, ok := os.LookupEnv(entry.name); ok {
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", entry.name, err)
+			}
+			*entry.port = port
+		}
+	}
+	return nil
+}
+
+// Address listening on given port of all interfaces
+func listenAddr(port int) string {
+	return net.JoinHostPort("", strconv.Itoa(port))
+}
@@ -5,44 +5,24 @@ type Matrix struct {
 	height             int
 	pixels             [][]uint8
 	surrounding_counts [][]int8
+	topology           Topology
 }
 
 // Make matrix object by providing pixel array
 // Ownership of pixel array is transferred to matrix object
-func MakeMatrixFromData(pixels [][]uint8, surrounding_counts [][]int8) Matrix {
+func MakeMatrixFromData(pixels [][]uint8, surrounding_counts [][]int8, topology Topology) Matrix {
 	return Matrix{
 		width:              len(pixels[0]),
 		height:             len(pixels),
 		pixels:             pixels,
 		surrounding_counts: surrounding_counts,
+		topology:           topology,
 	}
 }
 
-// Get positions of eight surrounding cells
-func (matrix *Matrix) getSurrounding(cell Cell) [8]Cell {
-	if cell.X == 0 || cell.Y == 0 || cell.X == matrix.width-1 || cell.Y == matrix.height-1 {
-		return [8]Cell{
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: cell.X, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: (cell.X + 1) % matrix.width, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: cell.Y},
-			{X: (cell.X + 1) % matrix.width, Y: cell.Y},
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: (cell.Y + 1) % matrix.height},
-			{X: cell.X, Y: (cell.Y + 1) % matrix.height},
-			{X: (cell.X + 1) % matrix.width, Y: (cell.Y + 1) % matrix.height},
-		}
-	} else {
-		return [8]Cell{
-			{X: cell.X - 1, Y: cell.Y - 1},
-			{X: cell.X, Y: cell.Y - 1},
-			{X: cell.X + 1, Y: cell.Y - 1},
-			{X: cell.X - 1, Y: cell.Y},
-			{X: cell.X + 1, Y: cell.Y},
-			{X: cell.X - 1, Y: cell.Y + 1},
-			{X: cell.X, Y: cell.Y + 1},
-			{X: cell.X + 1, Y: cell.Y + 1},
-		}
-	}
+// Get positions of surrounding cells and the number of them
+func (matrix *Matrix) getSurrounding(cell Cell) ([8]Cell, int) {
+	return getSurrounding(matrix.width, matrix.height, matrix.topology, cell)
 }
 
 // Check and flip cells if conditions satisfied
@@ -51,12 +31,14 @@ func (matrix *Matrix) getSurrounding(cell Cell) [8]Cell {
 func (matrix *Matrix) flip(cell Cell) {
 	if matrix.pixels[cell.Y][cell.X] == 0 {
 		matrix.pixels[cell.Y][cell.X] = 255
-		for _, surrounding := range matrix.getSurrounding(cell) {
+		surroundings, n := matrix.getSurrounding(cell)
+		for _, surrounding := range surroundings[:n] {
 			matrix.surrounding_counts[surrounding.Y][surrounding.X]++
 		}
 	} else {
 		matrix.pixels[cell.Y][cell.X] = 0
-		for _, surrounding := range matrix.getSurrounding(cell) {
+		surroundings, n := matrix.getSurrounding(cell)
+		for _, surrounding := range surroundings[:n] {
 			matrix.surrounding_counts[surrounding.Y][surrounding.X]--
 		}
 	}
@@ -64,29 +46,25 @@ func (matrix *Matrix) flip(cell Cell) {
 
 // Update matrix from given slice of flipped cells and
 // put cells in adjustment buffers if they are exchange target of that worker
-func (broker *Broker) updateMatrixAndGetAdjustments(flipped []Cell, adjust []Adjustment) {
+func (session *Session) updateMatrixAndGetAdjustments(flipped []Cell, adjust []Adjustment) {
 
 	for _, cell := range flipped {
-		broker.matrix.flip(cell)
+		session.matrix.flip(cell)
 
 		// Append cell to exchange targets
-		exchange_targets := broker.exchange_graph[cell.Y][cell.X]
-		if exchange_targets != 0 {
-			if broker.matrix.pixels[cell.Y][cell.X] == 0 {
+		exchange_targets := session.exchange_graph.targetsOf(cell)
+		if len(exchange_targets) != 0 {
+			if session.matrix.pixels[cell.Y][cell.X] == 0 {
 
 				// Flipped to black, decrementing surrounding counts
-				for node_index := 0; node_index != len(adjust); node_index++ {
-					if exchange_targets&(1<<node_index) != 0 {
-						adjust[node_index].Decrement = append(adjust[node_index].Decrement, cell)
-					}
+				for _, node_index := range exchange_targets {
+					adjust[node_index].Decrement = append(adjust[node_index].Decrement, cell)
 				}
 			} else {
 
 				// Flipped to white, incrementing surrounding counts
-				for node_index := 0; node_index != len(adjust); node_index++ {
-					if exchange_targets&(1<<node_index) != 0 {
-						adjust[node_index].Increment = append(adjust[node_index].Increment, cell)
-					}
+				for _, node_index := range exchange_targets {
+					adjust[node_index].Increment = append(adjust[node_index].Increment, cell)
 				}
 			}
 		}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"encoding/binary"
+	"encoding/gob"
 	"log"
 	"net"
 	"net/rpc"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -18,6 +19,10 @@ const (
 	EVENT_QUIT
 	EVENT_KILL
 	EVENT_FLIPPED
+	EVENT_SYNC            // Flipped cells sent so far bring local controller to the state of current turn (direct mode)
+	EVENT_RECOVERING      // Worker nodes failed and evaluation is being moved to others
+	EVENT_RECOVERED       // Evaluation continues on worker nodes left
+	EVENT_RECOVERY_FAILED // No worker nodes left to continue (durable state is kept on broker)
 )
 
 // Structure representing a connection to local controller
@@ -27,13 +32,17 @@ type Connection struct {
 }
 
 var nodes = make(map[Node]struct{})
-var mutex = new(sync.Mutex) // synchronise access to available nodes
+var draining = make(map[Node]struct{}) // Registered nodes not given to sessions any more
+var mutex = new(sync.Mutex)            // synchronise access to available nodes
+
+// Time between checks of a draining worker node released by sessions
+const drainPollInterval = time.Millisecond * 100
 
 // Write compressed slice of flipped cells to connection to local controller
-func (conn *Connection) writeCompressedFlipped(flipped_data []byte) {
+func (conn *Connection) writeCompressedFlipped(flipped_data []byte) error {
 
 	if len(flipped_data) == 0 {
-		return
+		return nil
 	}
 
 	conn.mutex.Lock()
@@ -42,7 +51,7 @@ func (conn *Connection) writeCompressedFlipped(flipped_data []byte) {
 	// Write type of message
 	_, err := conn.conn.Write([]byte{EVENT_FLIPPED})
 	if err != nil {
-		log.Panic(err.Error())
+		return err
 	}
 
 	// Write length of cell slice
@@ -50,33 +59,81 @@ func (conn *Connection) writeCompressedFlipped(flipped_data []byte) {
 	binary.PutVarint(length_bytes[:], int64(len(flipped_data)))
 	_, err = conn.conn.Write(length_bytes[:])
 	if err != nil {
-		log.Panic(err.Error())
+		return err
 	}
 
 	// Write cell slice
 	_, err = conn.conn.Write(flipped_data)
-	if err != nil {
-		log.Panic(err.Error())
-	}
+	return err
 }
 
-func (conn *Connection) writeEvent(event byte) {
+func (conn *Connection) writeEvent(event byte) error {
 
 	conn.mutex.Lock()
 	defer conn.mutex.Unlock()
 
 	// Write type of message
 	_, err := conn.conn.Write([]byte{event})
-	if err != nil {
-		log.Panic(err.Error())
+	return err
+}
+
+// Write save event tagged with ID of connection of requester
+func (conn *Connection) writeSave(requester uint64) error {
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	var message [9]byte
+	message[0] = EVENT_SAVE
+	binary.LittleEndian.PutUint64(message[1:], requester)
+	_, err := conn.conn.Write(message[:])
+	return err
+}
+
+// Write compressed slice of flipped cells to all attached local controllers
+func (session *Session) broadcastCompressedFlipped(flipped_data []byte) {
+	session.dropFailed(func(conn *Connection) error {
+		return conn.writeCompressedFlipped(flipped_data)
+	})
+}
+
+// Write event to all attached local controllers
+func (session *Session) broadcastEvent(event byte) {
+	session.dropFailed(func(conn *Connection) error {
+		return conn.writeEvent(event)
+	})
+}
+
+// Write save event to all attached local controllers
+func (session *Session) broadcastSave(requester uint64) {
+	session.dropFailed(func(conn *Connection) error {
+		return conn.writeSave(requester)
+	})
+}
+
+// Apply write function to attached connections and detach those failed
+// Evaluation continues when all local controllers detached so that they can attach again
+func (session *Session) dropFailed(write func(conn *Connection) error) {
+	attached := session.conns[:0]
+	for _, conn := range session.conns {
+		if err := write(conn); err != nil {
+			log.Printf("Connection to %s detached: %s", conn.conn.RemoteAddr().String(), err.Error())
+			conn.conn.Close()
+			continue
+		}
+		attached = append(attached, conn)
 	}
+	session.conns = attached
 }
 
 // Accept connection request from worker node
-func monitorNodes() {
+// Nodes requesting drain are released by sessions at the end of a turn before they are told to exit,
+// and drain is rejected if a session holding the node has no other node to move to
+// Nodes sending no heartbeat within given timeout are disconnected
+func monitorNodes(config Config, scheduler *Scheduler, heartbeat_timeout time.Duration) {
 
 	// Listen on connection requests from worker node
-	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: 2002})
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.WorkerPort})
 	if err != nil {
 		log.Panic(err.Error())
 	}
@@ -85,32 +142,97 @@ func monitorNodes() {
 		if err != nil {
 			log.Panic(err.Error())
 		}
-		ip := strings.Split(conn.RemoteAddr().String(), ":")[0]
-		client, err := rpc.DialHTTP("tcp", ip+":2000")
-		if err != nil {
-			log.Panic(err.Error())
-		}
-		go func() {
-			// Append to available worker node list
-			mutex.Lock()
-			node := Node{
-				ip:     conn.RemoteAddr().String(),
-				conn:   conn.(*net.TCPConn),
-				client: client,
+		go registerNode(conn, scheduler, heartbeat_timeout) // A stalled worker node does not hold up others
+	}
+}
+
+// Register worker node connected for registration and follow its heartbeats until it disconnects
+func registerNode(conn net.Conn, scheduler *Scheduler, heartbeat_timeout time.Duration) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	// Worker node reports the port of its RPC service on registration
+	var registration Registration
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	err := gob.NewDecoder(conn).Decode(&registration)
+	if err != nil {
+		log.Printf("Registration from %s rejected: %s", ip, err.Error())
+		conn.Close()
+		return
+	}
+	addr := net.JoinHostPort(ip, strconv.Itoa(registration.RPCPort))
+	client, err := rpc.DialHTTP("tcp", addr)
+	if err != nil {
+		log.Printf("Worker node %s unreachable: %s", ip, err.Error())
+		conn.Close()
+		return
+	}
+
+	// Append to available worker node list
+	mutex.Lock()
+	node := Node{
+		ip:       conn.RemoteAddr().String(),
+		addr:     addr,
+		conn:     conn.(*net.TCPConn),
+		client:   client,
+		cores:    registration.Cores,
+		capacity: float64(registration.Cores),
+	}
+	if registration.Speed > 0 {
+		node.capacity *= registration.Speed
+	}
+	nodes[node] = struct{}{}
+	mutex.Unlock()
+	log.Printf("Worker node %s registered (%d cores, %.0f cells/s per core)", ip, registration.Cores, registration.Speed)
+
+	// Read heartbeats until connection is reset, heartbeats stop or drain is requested
+	data := make([]byte, 1)
+	for {
+		conn.SetReadDeadline(time.Now().Add(heartbeat_timeout))
+		if _, err := conn.Read(data); err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				failNode(node, "no heartbeat within "+heartbeat_timeout.String())
 			}
-			nodes[node] = struct{}{}
-			mutex.Unlock()
-			// Blocking read on connection until connnection is reset
-			conn.SetReadDeadline(*new(time.Time))
-			data := make([]byte, 10000)
-			conn.Read(data)
+			break
+		}
+		if data[0] == DRAIN_REQUEST {
+			log.Printf("Worker node %s draining", ip)
 			mutex.Lock()
-			delete(nodes, node)
+			draining[node] = struct{}{}
 			mutex.Unlock()
-			log.Printf("Worker node %s disconnected", ip)
-		}()
-		log.Printf("Worker node %s registered", ip)
+			rejected := false
+			for scheduler.holds(node) && !rejected {
+				rejected = scheduler.stranded(node)
+				time.Sleep(drainPollInterval)
+			}
+			if rejected && scheduler.holds(node) {
+				log.Printf("Worker node %s drain rejected: last worker node of a running session", ip)
+				mutex.Lock()
+				delete(draining, node)
+				mutex.Unlock()
+				conn.Write([]byte{DRAIN_REJECTED})
+				continue
+			}
+			conn.Write([]byte{DRAIN_REQUEST})
+			conn.Close()
+			break
+		}
 	}
+	mutex.Lock()
+	delete(nodes, node)
+	delete(draining, node)
+	mutex.Unlock()
+	log.Printf("Worker node %s disconnected", ip)
+}
+
+// Disconnect a worker node not responding in time (it registers again if it recovers)
+// Calls waiting for its replies return with errors
+func failNode(node Node, reason string) {
+	log.Printf("Worker node %s failed: %s", node.addr, reason)
+	mutex.Lock()
+	delete(nodes, node)
+	mutex.Unlock()
+	node.conn.Close()
+	node.client.Close()
 }
 
 // Function retrieving available worker nodes
@@ -119,7 +241,9 @@ func getAvailableNodes() map[Node]struct{} {
 	mutex.Lock()
 	copied := make(map[Node]struct{})
 	for node := range nodes {
-		copied[node] = struct{}{}
+		if _, ok := draining[node]; !ok {
+			copied[node] = struct{}{}
+		}
 	}
 	mutex.Unlock()
 	return copied
@@ -1,6 +1,9 @@
 package main
 
-import "math"
+import (
+	"math"
+	"sort"
+)
 
 // Divide matrix into blocks (identical to that in parallel)
 func divideToBlocks(bp BrokerParams) []Block {
@@ -44,9 +47,9 @@ func divideToBlocks(bp BrokerParams) []Block {
 			}
 		}
 	}
-	// Find moderate partitioning
+	// Find moderate partitioning (blocks as square as possible)
 	i := 0
-	desired := math.Pow(float64(nthread), 0.5)
+	desired := math.Pow(float64(nthread)*float64(bp.ImageHeight)/float64(bp.ImageWidth), 0.5)
 	horizontal := 1
 	vertical := 1
 	for ; i != len(factors); i++ {
@@ -59,6 +62,13 @@ func divideToBlocks(bp BrokerParams) []Block {
 	for ; i != len(factors); i++ {
 		horizontal *= factors[len(factors)-i-1]
 	}
+	// Blocks must be at least two cells wide and high
+	if vertical > bp.ImageHeight/2 {
+		vertical = int(math.Max(float64(bp.ImageHeight/2), 1))
+	}
+	if horizontal > bp.ImageWidth/2 {
+		horizontal = int(math.Max(float64(bp.ImageWidth/2), 1))
+	}
 	// Return blocks
 	blocks := make([]Block, horizontal*vertical)
 	part_width := float64(bp.ImageWidth) / float64(horizontal)
@@ -77,53 +87,141 @@ func divideToBlocks(bp BrokerParams) []Block {
 	return blocks
 }
 
-// Simple function grouping a set of blocks and assign them to nodes
-func partitioning(nodes map[Node]struct{}, blocks []Block) []AssignedPartition {
+// Group blocks into partitions of sizes in proportion to the speed of worker nodes and assign them
+// Nodes are ordered by address so that the same nodes and speeds give the same assignment
+// Speeds are taken from capacity reported on registration for nodes not in given map
+func partitioning(nodes map[Node]struct{}, blocks []Block, speeds map[Node]float64) []AssignedPartition {
 
-	var assigned_partitions []AssignedPartition
-	node_counts := len(nodes)
-	block_counts := len(blocks)
-
-	// Convert map to slice
+	// Convert map to slice in order of address
 	nodes_slice := make([]Node, 0, len(nodes))
 	for node := range nodes {
 		nodes_slice = append(nodes_slice, node)
 	}
+	sort.Slice(nodes_slice, func(i, j int) bool {
+		if nodes_slice[i].addr != nodes_slice[j].addr {
+			return nodes_slice[i].addr < nodes_slice[j].addr
+		}
+		return nodes_slice[i].ip < nodes_slice[j].ip
+	})
+	speed := func(node Node) float64 {
+		return nodeSpeed(node, speeds)
+	}
 
-	// Assign a collection of blocks to each node
-	if block_counts <= node_counts {
-		// Blocks not enough to be assigned to every worker node
-		assigned_partitions = make([]AssignedPartition, block_counts)
-		for i := 0; i != len(blocks); i++ {
-			assigned_partitions[i] = AssignedPartition{nodes_slice[i], blocks[i : i+1]}
+	if len(blocks) <= len(nodes_slice) {
+		// Blocks not enough to be assigned to every worker node, so fastest nodes are chosen
+		fastest := append([]Node(nil), nodes_slice...)
+		sort.SliceStable(fastest, func(i, j int) bool {
+			return speed(fastest[i]) > speed(fastest[j])
+		})
+		chosen := make(map[Node]bool)
+		for _, node := range fastest[:len(blocks)] {
+			chosen[node] = true
+		}
+		assigned_partitions := make([]AssignedPartition, 0, len(blocks))
+		for _, node := range nodes_slice {
+			if chosen[node] {
+				i := len(assigned_partitions)
+				assigned_partitions = append(assigned_partitions, AssignedPartition{node, blocks[i : i+1]})
+			}
 		}
 		return assigned_partitions
-	} else {
-		// At least one worker node gets multiple blocks to evaluate
-		assigned_partitions = make([]AssignedPartition, node_counts)
-		avg_blocks := float64(len(blocks)) / float64(node_counts)
-		for i := 0; i != node_counts; i++ {
-			start_index := int(math.Round(float64(i) * avg_blocks))
-			end_index := int(math.Round(float64(i+1) * avg_blocks))
-			assigned_partitions[i] = AssignedPartition{nodes_slice[i], blocks[start_index:end_index]}
+	}
+
+	// At least one worker node gets multiple blocks to evaluate
+	// Each node gets consecutive blocks until their cells reach its share of all cells
+	total_cells := 0
+	for _, block := range blocks {
+		total_cells += blockCells(block)
+	}
+	total_speed := 0.0
+	for _, node := range nodes_slice {
+		total_speed += speed(node)
+	}
+	assigned_partitions := make([]AssignedPartition, len(nodes_slice))
+	start_index := 0
+	cells := 0
+	cumulative_speed := 0.0
+	for i, node := range nodes_slice {
+		cumulative_speed += speed(node)
+		target := float64(total_cells) * cumulative_speed / total_speed
+		end_index := start_index + 1
+		cells += blockCells(blocks[start_index])
+		// Take next block if it brings cells closer to target, leaving a block for each remaining node
+		for end_index < len(blocks)-(len(nodes_slice)-i-1) {
+			next_cells := cells + blockCells(blocks[end_index])
+			if i != len(nodes_slice)-1 && math.Abs(float64(next_cells)-target) >= math.Abs(float64(cells)-target) {
+				break
+			}
+			cells = next_cells
+			end_index++
 		}
+		assigned_partitions[i] = AssignedPartition{node, blocks[start_index:end_index]}
+		start_index = end_index
 	}
 	return assigned_partitions
 }
 
+// Speed of a worker node measured, or reported on registration if not measured yet
+func nodeSpeed(node Node, speeds map[Node]float64) float64 {
+	if measured, ok := speeds[node]; ok && measured > 0 {
+		return measured
+	}
+	if node.capacity > 0 {
+		return node.capacity
+	}
+	return 1
+}
+
+// Fraction of cores of a worker node used by a partition (one logic worker per block)
+func coreShare(node Node, partition Partition) float64 {
+	if node.cores <= len(partition) || node.cores == 0 {
+		return 1
+	}
+	return float64(len(partition)) / float64(node.cores)
+}
+
+// Number of cells in a block
+func blockCells(block Block) int {
+	return (block.End.X - block.Start.X) * (block.End.Y - block.Start.Y)
+}
+
+// Exchange targets of every cell (indices of other partitions having the cell in their surroundings)
+// Cells refer to an interned set of targets so that memory does not grow with the number of partitions
+type ExchangeGraph struct {
+	cells   [][]uint32 // Index of target set of each cell (0 if the cell has no exchange targets)
+	targets [][]int    // Distinct sets of partition indices in ascending order (first set is empty)
+}
+
+// Get indices of partitions a flipped cell should be sent to
+func (graph *ExchangeGraph) targetsOf(cell Cell) []int {
+	return graph.targets[graph.cells[cell.Y][cell.X]]
+}
+
 // Produce a exchange graph which contains exchange targets for each cell
-func getExchangeGraph(width, height int, assignments []AssignedPartition) [][]byte {
+func getExchangeGraph(width, height int, topology Topology, assignments []AssignedPartition) ExchangeGraph {
 
 	// Create 2D exchange graph
-	exchange_graph := make([][]byte, height)
+	graph := ExchangeGraph{
+		cells:   make([][]uint32, height),
+		targets: [][]int{nil},
+	}
 	for y := 0; y != height; y++ {
-		exchange_graph[y] = make([]byte, width)
+		graph.cells[y] = make([]uint32, width)
 	}
 
-	// Convert assignments to partition slice (discard node information)
-	partitions := make([]Partition, 0, len(assignments))
-	for i := 0; i != len(assignments); i++ {
-		partitions = append(partitions, assignments[i].Partition)
+	// Map every cell to the index of partition containing it
+	owners := make([][]int32, height)
+	for y := 0; y != height; y++ {
+		owners[y] = make([]int32, width)
+	}
+	for partition_index, assignment := range assignments {
+		for _, block := range assignment.Partition {
+			for y := block.Start.Y; y != block.End.Y; y++ {
+				for x := block.Start.X; x != block.End.X; x++ {
+					owners[y][x] = int32(partition_index)
+				}
+			}
+		}
 	}
 
 	// Function getting unsafe boundary of a block
@@ -154,33 +252,98 @@ func getExchangeGraph(width, height int, assignments []AssignedPartition) [][]by
 	// Function identifying every exchange target for a cell
 	// If any surrounding cells of this cell are in another partition,
 	// then that partition is an exchange target of this cell
-	identifyTarget := func(cell Cell, living_partition_index int) {
-		for _, surrounding := range getSurrounding(width, height, cell) {
-		partition_loop:
-			for partition_index, partition := range partitions {
-				if partition_index == living_partition_index {
-					continue
+	interned := make(map[[8]int]uint32) // Sorted target sets padded with -1
+	identifyTargets := func(cell Cell, living_partition_index int) {
+		var targets [8]int
+		count := 0
+		surroundings, n := getSurrounding(width, height, topology, cell)
+	surrounding_loop:
+		for _, surrounding := range surroundings[:n] {
+			partition_index := int(owners[surrounding.Y][surrounding.X])
+			if partition_index == living_partition_index {
+				continue
+			}
+			// Insert into sorted target set
+			i := 0
+			for ; i != count; i++ {
+				if targets[i] == partition_index {
+					continue surrounding_loop
 				}
-				for _, block := range partition {
-					if block.Start.X <= surrounding.X && surrounding.X < block.End.X &&
-						block.Start.Y <= surrounding.Y && surrounding.Y < block.End.Y {
-						exchange_graph[cell.Y][cell.X] |= 1 << partition_index
-						break partition_loop
-					}
+				if targets[i] > partition_index {
+					break
 				}
 			}
+			copy(targets[i+1:count+1], targets[i:count])
+			targets[i] = partition_index
+			count++
+		}
+		if count == 0 {
+			return
+		}
+
+		// Share identical target sets between cells
+		key := targets
+		for i := count; i != len(key); i++ {
+			key[i] = -1
+		}
+		index, ok := interned[key]
+		if !ok {
+			index = uint32(len(graph.targets))
+			interned[key] = index
+			graph.targets = append(graph.targets, append([]int(nil), targets[:count]...))
 		}
+		graph.cells[cell.Y][cell.X] = index
 	}
 
 	// For each cell at unsafe boundaries, iterating its surrounding cells
-	// any surrounding cells that is in another partition set its flag in exchange graph
-	for partition_index, partition := range partitions {
-		for _, block := range partition {
+	// any surrounding cells that is in another partition is added to its targets in exchange graph
+	for partition_index, assignment := range assignments {
+		for _, block := range assignment.Partition {
 			for _, boundary_cell := range getBoundary(block) {
-				identifyTarget(boundary_cell, partition_index)
+				identifyTargets(boundary_cell, partition_index)
 			}
 		}
 	}
 
-	return exchange_graph
+	return graph
+}
+
+// Get cells around a partition by distance (rings[d] holds cells at distance d+1, up to given depth)
+// Identical to that in worker so that both agree on ghost zone
+func getRings(width, height int, topology Topology, partition Partition, depth int) [][]Cell {
+
+	// Mark cells in partition
+	visited := make([]bool, width*height)
+	frontier := make([]Cell, 0, 1024)
+	for _, block := range partition {
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			for x := block.Start.X; x != block.End.X; x++ {
+				visited[y*width+x] = true
+			}
+		}
+		for x := block.Start.X; x != block.End.X; x++ {
+			frontier = append(frontier, Cell{X: x, Y: block.Start.Y}, Cell{X: x, Y: block.End.Y - 1})
+		}
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			frontier = append(frontier, Cell{X: block.Start.X, Y: y}, Cell{X: block.End.X - 1, Y: y})
+		}
+	}
+
+	// Expand one cell further for each ring
+	rings := make([][]Cell, 0, depth)
+	for d := 0; d != depth; d++ {
+		ring := make([]Cell, 0, len(frontier))
+		for _, cell := range frontier {
+			surroundings, n := getSurrounding(width, height, topology, cell)
+			for _, surrounding := range surroundings[:n] {
+				if !visited[surrounding.Y*width+surrounding.X] {
+					visited[surrounding.Y*width+surrounding.X] = true
+					ring = append(ring, surrounding)
+				}
+			}
+		}
+		rings = append(rings, ring)
+		frontier = ring
+	}
+	return rings
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Assign blocks of a matrix to given number of worker nodes
+func assignNodes(bp BrokerParams, workers int) []AssignedPartition {
+	nodes := make(map[Node]struct{})
+	for i := 0; i != workers; i++ {
+		nodes[Node{ip: fmt.Sprintf("10.0.0.%d:8030", i)}] = struct{}{}
+	}
+	return partitioning(nodes, divideToBlocks(bp), nil)
+}
+
+// Find exchange targets of a cell by checking every surrounding cell against every block
+func expectedTargets(bp BrokerParams, assignments []AssignedPartition, cell Cell) []int {
+	var targets []int
+	surroundings, n := getSurrounding(bp.ImageWidth, bp.ImageHeight, bp.Topology, cell)
+	for partition_index, assignment := range assignments {
+		contains := func(cell Cell) bool {
+			for _, block := range assignment.Partition {
+				if block.Start.X <= cell.X && cell.X < block.End.X && block.Start.Y <= cell.Y && cell.Y < block.End.Y {
+					return true
+				}
+			}
+			return false
+		}
+		if contains(cell) {
+			continue
+		}
+		for _, surrounding := range surroundings[:n] {
+			if contains(surrounding) {
+				targets = append(targets, partition_index)
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// TestExchangeGraph tests exchange targets of every cell for 1-64 worker nodes on all topologies.
+func TestExchangeGraph(t *testing.T) {
+	topologies := map[Topology]string{
+		Torus:       "torus",
+		DeadBorder:  "dead",
+		Reflective:  "reflective",
+		KleinBottle: "klein",
+		Cylinder:    "cylinder",
+	}
+	for topology, name := range topologies {
+		for _, workers := range []int{1, 2, 8, 9, 12, 16, 64} {
+			bp := BrokerParams{ImageWidth: 64, ImageHeight: 48, Threads: 64, Topology: topology}
+			t.Run(fmt.Sprintf("%dx%d-%dw-%s", bp.ImageWidth, bp.ImageHeight, workers, name), func(t *testing.T) {
+				assignments := assignNodes(bp, workers)
+				if len(assignments) != workers {
+					t.Fatalf("Expected %v partitions, got %v", workers, len(assignments))
+				}
+				graph := getExchangeGraph(bp.ImageWidth, bp.ImageHeight, bp.Topology, assignments)
+				for y := 0; y != bp.ImageHeight; y++ {
+					for x := 0; x != bp.ImageWidth; x++ {
+						cell := Cell{X: x, Y: y}
+						expected := expectedTargets(bp, assignments, cell)
+						got := graph.targetsOf(cell)
+						if len(expected) != len(got) || (len(expected) != 0 && !reflect.DeepEqual(expected, got)) {
+							t.Fatalf("Cell %v: expected targets %v, got %v", cell, expected, got)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestAdjustments tests that cells flipped at partition boundaries are sent to partitions beyond the eighth.
+func TestAdjustments(t *testing.T) {
+	bp := BrokerParams{ImageWidth: 64, ImageHeight: 64, Threads: 16}
+	assignments := assignNodes(bp, 16)
+	pixels, surrounding_counts := decompressMatrix(&BrokerParams{
+		ImageWidth:  bp.ImageWidth,
+		ImageHeight: bp.ImageHeight,
+		Pixels:      make([]byte, bp.ImageWidth*bp.ImageHeight/8+1),
+	})
+	session := &Session{
+		matrix:         MakeMatrixFromData(pixels, surrounding_counts, bp.Topology),
+		exchange_graph: getExchangeGraph(bp.ImageWidth, bp.ImageHeight, bp.Topology, assignments),
+	}
+
+	// Flip every cell on and off again
+	adjust := make([]Adjustment, len(assignments))
+	for _, flipped_to := range []string{"alive", "dead"} {
+		for i := range adjust {
+			adjust[i] = Adjustment{}
+		}
+		flipped := make([]Cell, 0, bp.ImageWidth*bp.ImageHeight)
+		for y := 0; y != bp.ImageHeight; y++ {
+			for x := 0; x != bp.ImageWidth; x++ {
+				flipped = append(flipped, Cell{X: x, Y: y})
+			}
+		}
+		session.updateMatrixAndGetAdjustments(flipped, adjust)
+
+		// Every partition receives exactly the cells surrounding it in other partitions
+		received := make([]map[Cell]bool, len(assignments))
+		for i := range received {
+			received[i] = make(map[Cell]bool)
+			cells := adjust[i].Increment
+			if flipped_to == "dead" {
+				cells = adjust[i].Decrement
+				if len(adjust[i].Increment) != 0 {
+					t.Errorf("Partition %v: unexpected increments %v", i, adjust[i].Increment)
+				}
+			} else if len(adjust[i].Decrement) != 0 {
+				t.Errorf("Partition %v: unexpected decrements %v", i, adjust[i].Decrement)
+			}
+			for _, cell := range cells {
+				received[i][cell] = true
+			}
+		}
+		for _, cell := range flipped {
+			targets := make(map[int]bool)
+			for _, partition_index := range expectedTargets(bp, assignments, cell) {
+				targets[partition_index] = true
+			}
+			for i := range assignments {
+				if targets[i] != received[i][cell] {
+					t.Fatalf("Cell %v flipped to %s: sent to partition %v is %v, expected %v",
+						cell, flipped_to, i, received[i][cell], targets[i])
+				}
+			}
+		}
+	}
+}
+
+// TestWeightedPartitioning tests partitions are sized by speed of worker nodes and assigned deterministically.
+func TestWeightedPartitioning(t *testing.T) {
+	bp := BrokerParams{ImageWidth: 512, ImageHeight: 512, Threads: 16}
+	blocks := divideToBlocks(bp)
+	slow := Node{ip: "10.0.0.1:8030", addr: "10.0.0.1:8031", cores: 4, capacity: 1000}
+	fast := Node{ip: "10.0.0.2:8030", addr: "10.0.0.2:8031", cores: 4, capacity: 3000}
+	nodes := map[Node]struct{}{slow: {}, fast: {}}
+
+	count := func(assignments []AssignedPartition, node Node) int {
+		for _, assignment := range assignments {
+			if assignment.Node == node {
+				return len(assignment.Partition)
+			}
+		}
+		return 0
+	}
+
+	t.Run("capacity", func(t *testing.T) {
+		assignments := partitioning(nodes, blocks, nil)
+		if count(assignments, slow) != 4 || count(assignments, fast) != 12 {
+			t.Errorf("Expected 4 and 12 blocks, got %v and %v", count(assignments, slow), count(assignments, fast))
+		}
+	})
+
+	t.Run("measured", func(t *testing.T) {
+		assignments := partitioning(nodes, blocks, map[Node]float64{slow: 3000, fast: 1000})
+		if count(assignments, slow) != 12 || count(assignments, fast) != 4 {
+			t.Errorf("Expected 12 and 4 blocks, got %v and %v", count(assignments, slow), count(assignments, fast))
+		}
+	})
+
+	t.Run("fastest", func(t *testing.T) {
+		single := divideToBlocks(BrokerParams{ImageWidth: 512, ImageHeight: 512, Threads: 1})
+		assignments := partitioning(nodes, single, nil)
+		if len(assignments) != 1 || assignments[0].Node != fast {
+			t.Errorf("Expected the only block assigned to %v, got %v", fast.addr, assignments)
+		}
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		expected := assignNodes(bp, 5)
+		for i := 0; i != 20; i++ {
+			if got := assignNodes(bp, 5); !reflect.DeepEqual(expected, got) {
+				t.Fatalf("Assignment changed between calls: %v, %v", expected, got)
+			}
+		}
+	})
+}
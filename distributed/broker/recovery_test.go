@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Worker node answering RPCs of broker without evaluating
+type fakeWorker struct {
+	inits int32         // Number of Init calls received
+	err   error         // Failure replied to Init
+	delay time.Duration // Time taken by Init
+}
+
+func (worker *fakeWorker) Init(wp WorkerParams, reply *struct{}) error {
+	atomic.AddInt32(&worker.inits, 1)
+	time.Sleep(worker.delay)
+	return worker.err
+}
+
+func (worker *fakeWorker) Ping(_ struct{}, _ *struct{}) error {
+	return nil
+}
+
+// Serve fake worker over RPC and return the address of its RPC service
+func serveFakeWorker(t *testing.T, worker *fakeWorker) *net.TCPAddr {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Worker", worker); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go http.Serve(listener, server)
+	return listener.Addr().(*net.TCPAddr)
+}
+
+// Register fake worker as available node directly (without registration connection of its own)
+func addFakeNode(t *testing.T, worker *fakeWorker) Node {
+	addr := serveFakeWorker(t, worker)
+	client, err := rpc.DialHTTP("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTCP("tcp", nil, addr) // Stands in for registration connection closed on failure
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := Node{ip: conn.LocalAddr().String(), addr: addr.String(), conn: conn, client: client, cores: 1, capacity: 1}
+	mutex.Lock()
+	nodes[node] = struct{}{}
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		delete(nodes, node)
+		mutex.Unlock()
+		client.Close()
+		conn.Close()
+	})
+	return node
+}
+
+// Check if a node with given RPC address is available
+func registered(addr string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for node := range nodes {
+		if node.addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Register a 16x16 session on a broker with no durable state
+func startTestSession(t *testing.T, broker *Broker) *Session {
+	session, err := broker.register(BrokerParams{ImageWidth: 16, ImageHeight: 16, Turns: 1, Threads: 1, Batch: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pixels := make([][]uint8, 16)
+	counts := make([][]int8, 16)
+	for y := range pixels {
+		pixels[y] = make([]uint8, 16)
+		counts[y] = make([]int8, 16)
+	}
+	session.matrix = MakeMatrixFromData(pixels, counts, session.bp.Topology)
+	t.Cleanup(func() { broker.scheduler.release(session) })
+	return session
+}
+
+// TestHeartbeat tests worker nodes registering to the broker, sending heartbeats and requesting drain.
+func TestHeartbeat(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	scheduler := NewScheduler()
+	go monitorNodes(Config{WorkerPort: port}, scheduler, 300*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	// Register fake worker through registration connection
+	register := func(t *testing.T) (*net.TCPConn, string) {
+		addr := serveFakeWorker(t, &fakeWorker{})
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		if err := gob.NewEncoder(conn).Encode(Registration{RPCPort: addr.Port, Cores: 1}); err != nil {
+			t.Fatal(err)
+		}
+		waitUntil(t, "registration", func() bool { return registered(addr.String()) })
+		return conn, addr.String()
+	}
+
+	t.Run("stalled", func(t *testing.T) {
+		// Connection sending no registration does not hold up registration of other worker nodes
+		stalled, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stalled.Close()
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		register(t)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected registration behind a stalled connection without waiting, took %v", elapsed)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		conn, addr := register(t)
+		for i := 0; i != 10; i++ {
+			conn.Write([]byte{HEARTBEAT})
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !registered(addr) {
+			t.Fatalf("Expected node sending heartbeats to stay registered")
+		}
+		waitUntil(t, "node without heartbeats failing", func() bool { return !registered(addr) })
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		conn, addr := register(t)
+		var node Node
+		mutex.Lock()
+		for registered := range nodes {
+			if registered.addr == addr {
+				node = registered
+			}
+		}
+		mutex.Unlock()
+
+		// Last node of a session cannot be drained
+		session := &Session{id: "drain", demand: 1}
+		scheduler.mutex.Lock()
+		scheduler.sessions = append(scheduler.sessions, session)
+		scheduler.owners[node] = session
+		scheduler.mutex.Unlock()
+		conn.Write([]byte{DRAIN_REQUEST})
+		if reply := readReply(t, conn); reply != DRAIN_REJECTED {
+			t.Fatalf("Expected drain to be rejected, got reply %v", reply)
+		}
+		if !registered(addr) {
+			t.Fatalf("Expected node to stay registered after rejected drain")
+		}
+
+		// Node is drained once released by session
+		scheduler.release(session)
+		conn.Write([]byte{DRAIN_REQUEST})
+		if reply := readReply(t, conn); reply != DRAIN_REQUEST {
+			t.Fatalf("Expected drain to complete, got reply %v", reply)
+		}
+		waitUntil(t, "drained node removed", func() bool { return !registered(addr) })
+	})
+}
+
+// Read a byte replied by broker on registration connection
+func readReply(t *testing.T, conn *net.TCPConn) byte {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 1)
+	if _, err := conn.Read(reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply[0]
+}
+
+// TestStart tests allocating worker nodes to a session with back-off between failed attempts.
+func TestStart(t *testing.T) {
+	broker := NewBroker(nil)
+	broker.rpc_timeout = 200 * time.Millisecond
+	broker.recovery_attempts = 3
+
+	t.Run("no nodes", func(t *testing.T) {
+		session := startTestSession(t, broker)
+		start := time.Now()
+		if _, err := session.start(0, 0); err == nil {
+			t.Fatalf("Expected failure without worker nodes")
+		}
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("Expected failure without waiting, took %v", elapsed)
+		}
+
+		// Back-off of 100ms then 200ms fits in 500ms, the next one of 400ms does not
+		start = time.Now()
+		if _, err := session.start(500*time.Millisecond, 500*time.Millisecond); err == nil {
+			t.Fatalf("Expected failure without worker nodes")
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 500*time.Millisecond {
+			t.Errorf("Expected to wait about 300ms, took %v", elapsed)
+		}
+	})
+
+	t.Run("node appears", func(t *testing.T) {
+		session := startTestSession(t, broker)
+		worker := &fakeWorker{}
+		go func() {
+			time.Sleep(150 * time.Millisecond)
+			addFakeNode(t, worker)
+		}()
+		if _, err := session.start(2*time.Second, 2*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if inits := atomic.LoadInt32(&worker.inits); inits != 1 {
+			t.Errorf("Expected 1 Init call, got %v", inits)
+		}
+	})
+
+	t.Run("nodes busy", func(t *testing.T) {
+		other := startTestSession(t, broker)
+		addFakeNode(t, &fakeWorker{})
+		if _, err := other.start(0, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// Waiting for nodes held by other sessions ends with the wait given
+		session := startTestSession(t, broker)
+		start := time.Now()
+		if _, err := session.start(300*time.Millisecond, 300*time.Millisecond); err == nil {
+			t.Fatalf("Expected failure with all worker nodes busy")
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+			t.Errorf("Expected to wait about 300ms, took %v", elapsed)
+		}
+	})
+
+	t.Run("dispatch failing", func(t *testing.T) {
+		session := startTestSession(t, broker)
+		worker := &fakeWorker{err: errors.New("out of memory")}
+		addFakeNode(t, worker)
+		if _, err := session.start(0, 0); err == nil {
+			t.Fatalf("Expected failure of dispatching")
+		}
+		if inits := atomic.LoadInt32(&worker.inits); inits != int32(broker.recovery_attempts) {
+			t.Errorf("Expected %v Init calls, got %v", broker.recovery_attempts, inits)
+		}
+	})
+
+	t.Run("rpc deadline", func(t *testing.T) {
+		session := startTestSession(t, broker)
+		worker := &fakeWorker{delay: 2 * time.Second}
+		node := addFakeNode(t, worker)
+		start := time.Now()
+		if _, err := session.start(0, 0); err == nil {
+			t.Fatalf("Expected failure of hung worker node")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected hung worker node to fail after RPC deadline, took %v", elapsed)
+		}
+		if registered(node.addr) {
+			t.Errorf("Expected hung worker node to be removed")
+		}
+	})
+}
+
+// Wait until condition holds, failing the test after 5 seconds
+func waitUntil(t *testing.T, name string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Time a new session waits for other sessions releasing worker nodes
+const allocateTimeout = time.Second * 30
+
+// Scheduler sharing worker nodes between running sessions
+// Available worker nodes are dealt to sessions in turn (in order of start) until every session gets as many
+// nodes as it has blocks. Sessions holding more than their share release nodes at the end of current turn,
+// and sessions holding less take free nodes, so a new session gets its share within a turn of every session.
+type Scheduler struct {
+	mutex    *sync.Mutex
+	sessions []*Session        // Running sessions in order of start
+	owners   map[Node]*Session // Session each worker node is assigned to
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		mutex:  new(sync.Mutex),
+		owners: make(map[Node]*Session),
+	}
+}
+
+// Allocate worker nodes to a session starting or recovering from failure of worker nodes
+// Nodes previously held by the session are released first
+// Block until other sessions release nodes if no free nodes are available, failing once deadline passes
+func (scheduler *Scheduler) allocate(session *Session, deadline time.Time) (map[Node]struct{}, error) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	registered := false
+	for _, running := range scheduler.sessions {
+		registered = registered || running == session
+	}
+	if !registered {
+		scheduler.sessions = append(scheduler.sessions, session)
+	}
+	scheduler.releaseAll(session)
+
+	for {
+		available := getAvailableNodes()
+		if len(available) == 0 {
+			scheduler.remove(session)
+			return nil, errors.New("no worker nodes available")
+		}
+		nodes := scheduler.take(session, available)
+		if len(nodes) != 0 {
+			return nodes, nil
+		}
+		if time.Now().After(deadline) {
+			scheduler.remove(session)
+			return nil, errors.New("all worker nodes busy with other sessions")
+		}
+		scheduler.mutex.Unlock()
+		time.Sleep(time.Millisecond * 100)
+		scheduler.mutex.Lock()
+	}
+}
+
+// Adjust nodes held by a session to its share (called at the end of turns)
+// Draining nodes are released first, unless the session would be left without nodes
+// Return new set of nodes and true if it has changed
+func (scheduler *Scheduler) rebalance(session *Session, held map[Node]struct{}) (map[Node]struct{}, bool) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	available := getAvailableNodes()
+	share := scheduler.shares(len(available))[session]
+	if share == 0 {
+		return held, false
+	}
+
+	// Release draining nodes
+	nodes := make(map[Node]struct{})
+	for node := range held {
+		if _, ok := available[node]; ok {
+			nodes[node] = struct{}{}
+		} else {
+			delete(scheduler.owners, node)
+		}
+	}
+	if len(nodes) > share {
+		// Release nodes above share
+		for node := range nodes {
+			if len(nodes) == share {
+				break
+			}
+			delete(nodes, node)
+			delete(scheduler.owners, node)
+		}
+	} else if len(nodes) < share {
+		// Take free nodes up to share
+		nodes = scheduler.take(session, available)
+	}
+	if len(nodes) != len(held) {
+		return nodes, true
+	}
+	for node := range nodes {
+		if _, ok := held[node]; !ok {
+			return nodes, true
+		}
+	}
+	return held, false
+}
+
+// Check if nodes held by a session are its share and none of them is draining, or it cannot take more nodes
+func (scheduler *Scheduler) balanced(session *Session, held map[Node]struct{}) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	available := getAvailableNodes()
+	share := scheduler.shares(len(available))[session]
+	if share == 0 {
+		return true
+	}
+	for node := range held {
+		if _, ok := available[node]; !ok {
+			return false
+		}
+	}
+	if len(held) > share {
+		return false
+	}
+	if len(held) < share {
+		for node := range available {
+			if _, ok := scheduler.owners[node]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Check if a node is held by any session
+func (scheduler *Scheduler) holds(node Node) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	_, ok := scheduler.owners[node]
+	return ok
+}
+
+// Check if a node is held by a session that would be left without nodes if it was released
+// (draining such a node never completes, as the session has nowhere to move its rows)
+func (scheduler *Scheduler) stranded(node Node) bool {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	owner, ok := scheduler.owners[node]
+	if !ok {
+		return false
+	}
+	return scheduler.shares(len(getAvailableNodes()))[owner] == 0
+}
+
+// Release all nodes of a session that ended
+func (scheduler *Scheduler) release(session *Session) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.releaseAll(session)
+	scheduler.remove(session)
+}
+
+// Take free nodes for a session up to its share and return all available nodes held by it
+func (scheduler *Scheduler) take(session *Session, available map[Node]struct{}) map[Node]struct{} {
+	share := scheduler.shares(len(available))[session]
+	nodes := make(map[Node]struct{})
+	for node, owner := range scheduler.owners {
+		if owner == session {
+			if _, ok := available[node]; ok {
+				nodes[node] = struct{}{}
+			} else {
+				delete(scheduler.owners, node) // Node disconnected
+			}
+		}
+	}
+	for node := range available {
+		if len(nodes) >= share {
+			break
+		}
+		if _, ok := scheduler.owners[node]; !ok {
+			scheduler.owners[node] = session
+			nodes[node] = struct{}{}
+		}
+	}
+	return nodes
+}
+
+// Deal given number of nodes to running sessions in turn
+// Sessions started later get no nodes if there are more sessions than nodes
+func (scheduler *Scheduler) shares(available int) map[*Session]int {
+	shares := make(map[*Session]int)
+	for dealt := true; dealt && available != 0; {
+		dealt = false
+		for _, session := range scheduler.sessions {
+			if available != 0 && shares[session] < session.demand {
+				shares[session]++
+				available--
+				dealt = true
+			}
+		}
+	}
+	return shares
+}
+
+func (scheduler *Scheduler) releaseAll(session *Session) {
+	for node, owner := range scheduler.owners {
+		if owner == session {
+			delete(scheduler.owners, node)
+		}
+	}
+}
+
+func (scheduler *Scheduler) remove(session *Session) {
+	for i, running := range scheduler.sessions {
+		if running == session {
+			scheduler.sessions = append(scheduler.sessions[:i], scheduler.sessions[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Worker nodes and sessions sharing them (session 0 is the one checked)
+type schedulerCase struct {
+	name     string
+	nodes    int     // Number of registered worker nodes
+	draining []int   // Indices of nodes requesting drain
+	demands  []int   // Number of blocks of each session in order of start
+	held     [][]int // Indices of nodes held by each session
+}
+
+// Register worker nodes and sessions of a case (registered nodes are restored when test ends)
+func makeScheduler(t *testing.T, c schedulerCase) (*Scheduler, []*Session, []Node) {
+	mutex.Lock()
+	saved_nodes, saved_draining := nodes, draining
+	nodes, draining = make(map[Node]struct{}), make(map[Node]struct{})
+	registered := make([]Node, c.nodes)
+	for i := range registered {
+		registered[i] = Node{ip: fmt.Sprintf("10.0.0.%d:8030", i)}
+		nodes[registered[i]] = struct{}{}
+	}
+	for _, i := range c.draining {
+		draining[registered[i]] = struct{}{}
+	}
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		nodes, draining = saved_nodes, saved_draining
+		mutex.Unlock()
+	})
+
+	scheduler := NewScheduler()
+	sessions := make([]*Session, len(c.demands))
+	for i, demand := range c.demands {
+		sessions[i] = &Session{id: fmt.Sprint(i), demand: demand}
+		scheduler.sessions = append(scheduler.sessions, sessions[i])
+	}
+	for i, held := range c.held {
+		for _, node := range held {
+			scheduler.owners[registered[node]] = sessions[i]
+		}
+	}
+	return scheduler, sessions, registered
+}
+
+// Nodes held by session 0 of a case
+func heldNodes(c schedulerCase, registered []Node) map[Node]struct{} {
+	held := make(map[Node]struct{})
+	if len(c.held) != 0 {
+		for _, node := range c.held[0] {
+			held[registered[node]] = struct{}{}
+		}
+	}
+	return held
+}
+
+// TestShares tests nodes dealt to sessions in turn up to their number of blocks.
+func TestShares(t *testing.T) {
+	tests := []struct {
+		demands   []int
+		available int
+		expected  []int
+	}{
+		{[]int{4}, 2, []int{2}},
+		{[]int{4}, 8, []int{4}},
+		{[]int{4, 4}, 3, []int{2, 1}},
+		{[]int{1, 4}, 4, []int{1, 3}},
+		{[]int{2, 2, 2}, 2, []int{1, 1, 0}},
+		{[]int{1}, 0, []int{0}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%v-%d", test.demands, test.available), func(t *testing.T) {
+			scheduler, sessions, _ := makeScheduler(t, schedulerCase{demands: test.demands})
+			shares := scheduler.shares(test.available)
+			got := make([]int, len(sessions))
+			for i, session := range sessions {
+				got[i] = shares[session]
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("Expected shares %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+// TestRebalance tests nodes taken and released by a session at the end of a turn.
+func TestRebalance(t *testing.T) {
+	tests := []struct {
+		schedulerCase
+		count    int   // Number of nodes held after
+		changed  bool  // Nodes held changed
+		released []int // Indices of nodes that must not be held after
+	}{
+		{schedulerCase{name: "take free nodes", nodes: 4, demands: []int{4}, held: [][]int{{0}}}, 4, true, nil},
+		{schedulerCase{name: "release above share", nodes: 4, demands: []int{4, 4}, held: [][]int{{0, 1, 2, 3}}}, 2, true, nil},
+		{schedulerCase{name: "release draining", nodes: 3, draining: []int{0}, demands: []int{2}, held: [][]int{{0, 1}}}, 2, true, []int{0}},
+		{schedulerCase{name: "keep last draining node", nodes: 1, draining: []int{0}, demands: []int{1}, held: [][]int{{0}}}, 1, false, nil},
+		{schedulerCase{name: "at share", nodes: 2, demands: []int{2}, held: [][]int{{0, 1}}}, 2, false, nil},
+		{schedulerCase{name: "nodes held by others", nodes: 2, demands: []int{2, 2}, held: [][]int{{0}, {1}}}, 1, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler, sessions, registered := makeScheduler(t, test.schedulerCase)
+			held, changed := scheduler.rebalance(sessions[0], heldNodes(test.schedulerCase, registered))
+			if len(held) != test.count || changed != test.changed {
+				t.Errorf("Expected %v nodes (changed %v), got %v (changed %v)", test.count, test.changed, len(held), changed)
+			}
+			for _, node := range test.released {
+				if _, ok := held[registered[node]]; ok {
+					t.Errorf("Expected node %v to be released", node)
+				}
+				if scheduler.holds(registered[node]) {
+					t.Errorf("Expected node %v not to be held by any session", node)
+				}
+			}
+		})
+	}
+}
+
+// TestBalanced tests whether a session needs rebalancing at the end of a turn.
+func TestBalanced(t *testing.T) {
+	tests := []struct {
+		schedulerCase
+		expected bool
+	}{
+		{schedulerCase{name: "at share", nodes: 2, demands: []int{2}, held: [][]int{{0, 1}}}, true},
+		{schedulerCase{name: "below share with free nodes", nodes: 2, demands: []int{2}, held: [][]int{{0}}}, false},
+		{schedulerCase{name: "below share without free nodes", nodes: 3, demands: []int{4, 1}, held: [][]int{{0, 1}, {2}}}, true},
+		{schedulerCase{name: "above share", nodes: 4, demands: []int{4, 4}, held: [][]int{{0, 1, 2, 3}}}, false},
+		{schedulerCase{name: "draining node held", nodes: 3, draining: []int{0}, demands: []int{2}, held: [][]int{{0, 1}}}, false},
+		{schedulerCase{name: "no share", nodes: 1, draining: []int{0}, demands: []int{1}, held: [][]int{{0}}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler, sessions, registered := makeScheduler(t, test.schedulerCase)
+			if got := scheduler.balanced(sessions[0], heldNodes(test.schedulerCase, registered)); got != test.expected {
+				t.Errorf("Expected balanced %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+// TestStranded tests that a draining node is stranded only when its session has no other node to move to.
+func TestStranded(t *testing.T) {
+	tests := []struct {
+		schedulerCase
+		expected bool
+	}{
+		{schedulerCase{name: "only node of session", nodes: 1, draining: []int{0}, demands: []int{1}, held: [][]int{{0}}}, true},
+		{schedulerCase{name: "other node available", nodes: 2, draining: []int{0}, demands: []int{1}, held: [][]int{{0}}}, false},
+		{schedulerCase{name: "other node taken by earlier session", nodes: 2, draining: []int{1}, demands: []int{1, 1}, held: [][]int{{0}, {1}}}, true},
+		{schedulerCase{name: "not held", nodes: 1, draining: []int{0}, demands: []int{1}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler, _, registered := makeScheduler(t, test.schedulerCase)
+			if got := scheduler.stranded(registered[test.draining[0]]); got != test.expected {
+				t.Errorf("Expected stranded %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
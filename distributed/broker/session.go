@@ -0,0 +1,728 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/rpc"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pausePollInterval = time.Millisecond * 100 // Time between checks of worker node shares while a session is paused
+	syncInterval      = time.Millisecond * 200 // Time between collecting state from worker nodes in direct mode
+	batchThreshold    = time.Millisecond       // Round-trip time to worker nodes above which turns are evaluated in batches
+	maxBatchDepth     = 16                     // Maximum number of turns per RPC (depth of ghost zone)
+	batchOverhead     = 10                     // Batches are deepened until round-trip time is this fraction of evaluation
+	loadCheckInterval = time.Second * 2        // Time between checks of worker nodes falling behind
+	stragglerRatio    = 1.25                   // Ratio of slowest turn time to that of balanced partitions triggering repartitioning
+	minStragglerTime  = time.Microsecond * 100 // Turn time below which worker nodes are not repartitioned
+	speedSmoothing    = 0.2                    // Weight of latest measurement in speed of worker nodes
+
+	recoveryInitialBackoff = time.Millisecond * 100 // Delay before first retry of recovery
+	recoveryMaxBackoff     = time.Second * 5        // Maximum delay between retries of recovery
+)
+
+// Counter of assignments of partitions to worker nodes
+var generation uint64
+
+// Evaluation of one local controller running on broker
+// Sessions run independently on disjoint sets of worker nodes allocated by scheduler
+type Session struct {
+	broker         *Broker
+	id             string
+	conns          []*Connection // Connections to local controllers attached to session (owned by loop)
+	attach_chan    chan AttachRequest
+	edit_chan      chan EditRequest
+	step_chan      chan StepRequest
+	save_chan      chan uint64 // Requesters of saves (zero when saved by the user)
+	event_chan     chan byte
+	done           chan struct{} // Closed when session ends
+	bp             BrokerParams
+	turn           int
+	synced         int // Number of completed turns matrix is at (behind turn between collections in direct mode)
+	matrix         Matrix
+	exchange_graph ExchangeGraph
+	demand         int               // Number of blocks (worker nodes above that are left idle)
+	nodes          map[Node]struct{} // Worker nodes allocated to session
+	speeds         map[Node]float64  // Cells evaluated per second measured on each worker node (scaled to all its cores)
+	batching       bool              // Worker nodes evaluate multiple turns per RPC
+	depth          int               // Number of turns of next batch
+	rings          [][][]Cell        // Cells of ghost zone of each assignment by distance
+}
+
+// Request of local controller attaching to running session
+type AttachRequest struct {
+	conn  *Connection        // Connection to attach (nil to get state only)
+	reply chan *BrokerParams // Current state of session
+}
+
+// Request of local controller setting cells of a region
+type EditRequest struct {
+	args  EditArgs
+	reply chan error // Error if region is outside of matrix or session stopped
+}
+
+// Request of local controller evaluating turns of a session and holding it after them
+type StepRequest struct {
+	turns int
+	reply chan int // Turn session is held at
+}
+
+// Send event from local controller to session (handled at the end of current turn)
+func (session *Session) send(event byte) error {
+	select {
+	case session.event_chan <- event:
+		return nil
+	case <-session.done:
+		return errors.New("session " + session.id + " not running")
+	}
+}
+
+// Send save request from local controller to session (handled at the end of current turn)
+func (session *Session) save(requester uint64) error {
+	select {
+	case session.save_chan <- requester:
+		return nil
+	case <-session.done:
+		return errors.New("session " + session.id + " not running")
+	}
+}
+
+// Allocate worker nodes and dispatch matrix to them
+// Failed attempts are retried with exponential back-off up to the number of recovery attempts,
+// allocation is retried until wait passes when no worker nodes are available,
+// and nodes busy with other sessions are waited for until busy_wait passes
+func (session *Session) start(wait, busy_wait time.Duration) ([]AssignedPartition, error) {
+	deadline := time.Now().Add(wait)
+	busy_deadline := time.Now().Add(busy_wait)
+	backoff := recoveryInitialBackoff
+	for attempt := 1; ; attempt++ {
+		nodes, err := session.broker.scheduler.allocate(session, busy_deadline)
+		if err == nil {
+			session.nodes = nodes
+			var assignments []AssignedPartition
+			assignments, err = session.dispatch()
+			if err == nil {
+				return assignments, nil
+			}
+			log.Printf("Session %s dispatching failed (attempt %d): %s", session.id, attempt, err.Error())
+			if attempt >= session.broker.recovery_attempts {
+				return nil, fmt.Errorf("dispatching failed after %d attempts: %s", attempt, err.Error())
+			}
+		} else if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		} else {
+			log.Printf("Session %s waiting for worker nodes: %s", session.id, err.Error())
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > recoveryMaxBackoff {
+			backoff = recoveryMaxBackoff
+		}
+	}
+}
+
+// Partition matrix among allocated worker nodes and transmit current state of matrix
+func (session *Session) dispatch() ([]AssignedPartition, error) {
+
+	// Partitioning
+	bp := session.bp
+	blocks := divideToBlocks(bp)
+	assignments := partitioning(session.nodes, blocks, session.speeds)
+	session.exchange_graph = getExchangeGraph(bp.ImageWidth, bp.ImageHeight, bp.Topology, assignments)
+
+	// Peers exchanging boundary flips directly
+	var peers []Peer
+	if bp.Direct {
+		peers = make([]Peer, len(assignments))
+		for i, assignment := range assignments {
+			peers[i] = Peer{Address: assignment.Node.addr, Partition: assignment.Partition}
+		}
+	}
+	assignment_generation := atomic.AddUint64(&generation, 1)
+
+	// Evaluate multiple turns per RPC if requested or round-trip time to worker nodes is long
+	session.batching = false
+	max_batch := 0
+	if !bp.Direct && bp.Batch != 1 {
+		if bp.Batch > 1 {
+			session.batching = true
+			session.depth = bp.Batch
+			max_batch = bp.Batch
+		} else if rtt, err := session.ping(assignments); err != nil {
+			return assignments, err
+		} else if rtt >= batchThreshold {
+			log.Printf("Session %s evaluating in batches: round-trip time %v", session.id, rtt)
+			session.batching = true
+			session.depth = 2
+			max_batch = maxBatchDepth
+		}
+	}
+	session.rings = nil
+	if session.batching {
+		session.rings = make([][][]Cell, len(assignments))
+		for i, assignment := range assignments {
+			session.rings[i] = getRings(bp.ImageWidth, bp.ImageHeight, bp.Topology, assignment.Partition, max_batch)
+		}
+	}
+
+	// Dispatch matrix data
+	call_chan := make(chan *rpc.Call, len(assignments))
+	calls := make(map[*rpc.Call]Node, len(assignments))
+	for i, assignment := range assignments {
+		// Transmit rows in partition only
+		pixels_in_partition := make([][]uint8, bp.ImageHeight)
+		surrounding_counts_in_partition := make([][]int8, bp.ImageHeight)
+		for _, block := range assignment.Partition {
+			for y := block.Start.Y; y != block.End.Y; y++ {
+				if pixels_in_partition[y] == nil {
+					pixels_in_partition[y] = session.matrix.pixels[y]
+					surrounding_counts_in_partition[y] = session.matrix.surrounding_counts[y]
+				}
+			}
+		}
+		wp := WorkerParams{
+			Turns:             bp.Turns,
+			Threads:           bp.Threads,
+			ImageWidth:        bp.ImageWidth,
+			ImageHeight:       bp.ImageHeight,
+			Rule:              bp.Rule,
+			Topology:          bp.Topology,
+			Pixels:            pixels_in_partition,
+			SurroundingCounts: surrounding_counts_in_partition,
+			Partition:         assignment.Partition,
+			SizeInt:           bp.SizeInt,
+			Direct:            bp.Direct,
+			Index:             i,
+			Peers:             peers,
+			Generation:        assignment_generation,
+			MaxBatch:          max_batch,
+			Timeout:           session.broker.rpc_timeout / 2,
+		}
+		var reply struct{}
+		calls[assignment.Node.client.Go("Worker.Init", wp, &reply, call_chan)] = assignment.Node
+	}
+
+	// Check if all RPC calls succeeded
+	return assignments, session.await(calls, call_chan, nil)
+}
+
+// Wait for calls to worker nodes to complete, calling given function on each successful call if not nil
+// Nodes not replying within timeout are declared failed, so that evaluation does not stall on hung nodes
+// Return the first error of calls
+func (session *Session) await(calls map[*rpc.Call]Node, call_chan chan *rpc.Call, received func(call *rpc.Call)) error {
+	timer := time.NewTimer(session.broker.rpc_timeout)
+	defer timer.Stop()
+	var err error
+	for len(calls) != 0 {
+		select {
+		case call := <-call_chan:
+			if _, ok := calls[call]; !ok {
+				continue // Call of a node already declared failed
+			}
+			delete(calls, call)
+			if call.Error != nil {
+				if err == nil {
+					err = call.Error
+				}
+			} else if received != nil {
+				received(call)
+			}
+		case <-timer.C:
+			for call, node := range calls {
+				failNode(node, call.ServiceMethod+" not replied within "+session.broker.rpc_timeout.String())
+			}
+			return errors.New("worker nodes not replied in time")
+		}
+	}
+	return err
+}
+
+// Measure the longest round-trip time to worker nodes
+func (session *Session) ping(assignments []AssignedPartition) (time.Duration, error) {
+	call_chan := make(chan *rpc.Call, len(assignments))
+	calls := make(map[*rpc.Call]Node, len(assignments))
+	start := time.Now()
+	for _, assignment := range assignments {
+		calls[assignment.Node.client.Go("Worker.Ping", struct{}{}, &struct{}{}, call_chan)] = assignment.Node
+	}
+	err := session.await(calls, call_chan, nil)
+	return time.Since(start), err
+}
+
+// Evaluate given number of turns on worker nodes in one RPC each (batch mode)
+// Depth of next batch is chosen from round-trip time measured unless given by local controller
+func (session *Session) batch(assignments []AssignedPartition, depth int) ([]*BatchReply, error) {
+
+	// Send alive cells of ghost zone as deep as the batch
+	call_chan := make(chan *rpc.Call, len(assignments))
+	calls := make(map[*rpc.Call]Node, len(assignments))
+	replies := make([]*BatchReply, len(assignments))
+	starts := make(map[*rpc.Call]time.Time)
+	for i, assignment := range assignments {
+		ghost := make([]Cell, 0, 1024)
+		for _, ring := range session.rings[i][:depth] {
+			for _, cell := range ring {
+				if session.matrix.pixels[cell.Y][cell.X] != 0 {
+					ghost = append(ghost, cell)
+				}
+			}
+		}
+		args := BatchArgs{Turns: depth, Ghost: compressFlipped(ghost, session.bp.SizeInt)}
+		replies[i] = new(BatchReply)
+		start := time.Now()
+		call := assignment.Node.client.Go("Worker.Batch", args, replies[i], call_chan)
+		calls[call] = assignment.Node
+		starts[call] = start
+	}
+
+	// Measure round-trip time (time of call not spent evaluating) and evaluation time
+	var rtt, elapsed time.Duration
+	err := session.await(calls, call_chan, func(call *rpc.Call) {
+		reply := call.Reply.(*BatchReply)
+		if call_rtt := time.Since(starts[call]) - reply.Elapsed; call_rtt > rtt {
+			rtt = call_rtt
+		}
+		if reply.Elapsed > elapsed {
+			elapsed = reply.Elapsed
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if session.bp.Batch == 0 {
+		session.depth = batchDepth(rtt, elapsed/time.Duration(depth))
+	}
+	return replies, nil
+}
+
+// Number of turns per batch keeping round-trip time within a fraction of evaluation time
+func batchDepth(rtt, turn_time time.Duration) int {
+	if turn_time <= 0 {
+		return maxBatchDepth
+	}
+	depth := int(rtt*batchOverhead/turn_time) + 1
+	if depth > maxBatchDepth {
+		depth = maxBatchDepth
+	}
+	return depth
+}
+
+// Update measured speed of a worker node from time spent evaluating one turn of its partition
+func (session *Session) measure(assignment AssignedPartition, elapsed time.Duration) {
+	if elapsed <= 0 {
+		return
+	}
+	cells := 0
+	for _, block := range assignment.Partition {
+		cells += blockCells(block)
+	}
+	speed := float64(cells) / elapsed.Seconds() / coreShare(assignment.Node, assignment.Partition)
+	if last, ok := session.speeds[assignment.Node]; ok {
+		speed = last + (speed-last)*speedSmoothing
+	}
+	session.speeds[assignment.Node] = speed
+}
+
+// Expected time of a turn on the slowest worker node of given assignments
+func (session *Session) turnTime(assignments []AssignedPartition) time.Duration {
+	slowest := 0.0
+	for _, assignment := range assignments {
+		cells := 0
+		for _, block := range assignment.Partition {
+			cells += blockCells(block)
+		}
+		speed := nodeSpeed(assignment.Node, session.speeds) * coreShare(assignment.Node, assignment.Partition)
+		slowest = math.Max(slowest, float64(cells)/speed)
+	}
+	return time.Duration(slowest * float64(time.Second))
+}
+
+// Collect alive cells from worker nodes (direct mode)
+// Cells flipped since last collected are applied to matrix and streamed to local controllers
+func (session *Session) collect(assignments []AssignedPartition) error {
+
+	call_chan := make(chan *rpc.Call, len(assignments))
+	calls := make(map[*rpc.Call]Node, len(assignments))
+	for _, assignment := range assignments {
+		var alive_data []byte
+		calls[assignment.Node.client.Go("Worker.Collect", struct{}{}, &alive_data, call_chan)] = assignment.Node
+	}
+	alive := make([]bool, session.matrix.width*session.matrix.height)
+	err := session.await(calls, call_chan, func(call *rpc.Call) {
+		for _, cell := range decompressFlipped(*call.Reply.(*[]byte), session.bp.SizeInt) {
+			alive[cell.Y*session.matrix.width+cell.X] = true
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Find flipped cells
+	flipped := make([]Cell, 0, 1024)
+	for y := 0; y != session.matrix.height; y++ {
+		for x := 0; x != session.matrix.width; x++ {
+			if (session.matrix.pixels[y][x] != 0) != alive[y*session.matrix.width+x] {
+				flipped = append(flipped, Cell{X: x, Y: y})
+			}
+		}
+	}
+	for _, cell := range flipped {
+		session.matrix.flip(cell)
+	}
+	session.synced = session.turn
+	session.broadcastCompressedFlipped(compressFlipped(flipped, session.bp.SizeInt))
+	session.broadcastEvent(EVENT_SYNC)
+	return nil
+}
+
+func (session *Session) loop(assignments []AssignedPartition) {
+
+	defer session.broker.end(session)
+	defer func() {
+		for _, conn := range session.conns {
+			conn.conn.Close()
+			log.Print("Connection closed: " + conn.conn.RemoteAddr().String())
+		}
+		session.conns = nil
+	}()
+	store := session.broker.store
+
+	var replies []*NextReply
+	var adjustment_buffers []Adjustment
+	var call_chan chan *rpc.Call
+
+	// Create buffers for a new assignment of partitions
+	assign := func(reassigned []AssignedPartition) {
+		assignments = reassigned
+		replies = make([]*NextReply, len(assignments))
+		adjustment_buffers = make([]Adjustment, len(assignments))
+		for i := 0; i != len(assignments); i++ {
+			adjustment_buffers[i] = Adjustment{
+				Increment: make([]Cell, 0, 1024),
+				Decrement: make([]Cell, 0, 1024),
+			}
+		}
+		call_chan = make(chan *rpc.Call, len(assignments))
+	}
+	assign(assignments)
+
+	// Recover task when any worker nodes getting offline
+	// Local controllers are told when recovery starts and whether it succeeded
+	// Durable state is kept for the local controller to continue if no worker nodes are left
+	recover_evaluation := func() bool {
+		log.Printf("Recover: session %s %dx%dx%d-%d (from %d)", session.id, session.bp.ImageWidth,
+			session.bp.ImageHeight, session.bp.Turns, session.bp.Threads, session.synced)
+		session.broadcastEvent(EVENT_RECOVERING)
+
+		// Turns after last collection are evaluated again in direct mode
+		// Local controllers are detached so that they attach again at the turn evaluation continues from
+		if session.synced != session.turn {
+			session.turn = session.synced
+			for _, conn := range session.conns {
+				conn.conn.Close()
+			}
+			session.conns = nil
+		}
+		reassigned, err := session.start(session.broker.recovery_wait, session.broker.recovery_wait)
+		if err != nil {
+			log.Printf("Session %s stopped: %s", session.id, err.Error())
+			store.save(session.bp, &session.matrix, session.turn)
+			session.broadcastEvent(EVENT_RECOVERY_FAILED)
+			return false
+		}
+		log.Printf("Session %s recovered: %d worker nodes (at %d)", session.id, len(session.nodes), session.turn)
+		session.broadcastEvent(EVENT_RECOVERED)
+		assign(reassigned)
+		return true
+	}
+
+	// Collect state of worker nodes before it is needed in direct mode
+	last_sync := time.Now()
+	sync_state := func() bool {
+		if session.synced == session.turn {
+			return true
+		}
+		if err := session.collect(assignments); err != nil {
+			log.Print(err.Error())
+			return recover_evaluation()
+		}
+		last_sync = time.Now()
+		return true
+	}
+
+	// Take or release worker nodes as other sessions start or end
+	rebalance := func() bool {
+		if session.broker.scheduler.balanced(session, session.nodes) {
+			return true
+		}
+		if !sync_state() {
+			return false
+		}
+		nodes, changed := session.broker.scheduler.rebalance(session, session.nodes)
+		if !changed {
+			return true
+		}
+		log.Printf("Session %s rebalanced: %d worker nodes (at %d)", session.id, len(nodes), session.turn)
+		session.nodes = nodes
+		reassigned, err := session.dispatch()
+		if err != nil {
+			log.Print(err.Error())
+			return recover_evaluation()
+		}
+		assign(reassigned)
+		return true
+	}
+
+	// Repartition if a worker node falls behind others (measured speeds are used for new partitions)
+	last_load_check := time.Now()
+	balance_load := func() bool {
+		if len(assignments) < 2 || time.Since(last_load_check) < loadCheckInterval {
+			return true
+		}
+		last_load_check = time.Now()
+		current := session.turnTime(assignments)
+		balanced := session.turnTime(partitioning(session.nodes, divideToBlocks(session.bp), session.speeds))
+		if current < minStragglerTime || float64(current) < float64(balanced)*stragglerRatio {
+			return true
+		}
+		if !sync_state() {
+			return false
+		}
+		log.Printf("Session %s repartitioned: turn time %v, expected %v after (at %d)",
+			session.id, current, balanced, session.turn)
+		reassigned, err := session.dispatch()
+		if err != nil {
+			log.Print(err.Error())
+			return recover_evaluation()
+		}
+		assign(reassigned)
+		return true
+	}
+
+	// Handle events from local controller at the end of a turn (polled while paused or held)
+	// Return false when the session ends
+	pause_flag := false
+	held, target := session.bp.Held, session.turn // Held sessions stop at target turn until stepped further
+	step := func(request StepRequest) {
+		if !held {
+			held, target = true, session.turn
+		}
+		target += request.turns
+		request.reply <- target
+	}
+	handle_events := func() bool {
+		for {
+			select {
+			case request := <-session.attach_chan:
+				if !sync_state() {
+					return false
+				}
+				session.attach(request, pause_flag)
+				continue
+			case request := <-session.edit_chan:
+				if !sync_state() {
+					request.reply <- errors.New("session " + session.id + " stopped")
+					return false
+				}
+				err := session.edit(request.args)
+				request.reply <- err
+				if err != nil {
+					continue
+				}
+				// Worker nodes continue from the edited matrix
+				reassigned, err := session.dispatch()
+				if err != nil {
+					log.Print(err.Error())
+					if !recover_evaluation() {
+						return false
+					}
+				} else {
+					assign(reassigned)
+				}
+				continue
+			case request := <-session.step_chan:
+				step(request)
+				continue
+			case requester := <-session.save_chan:
+				if !sync_state() {
+					return false
+				}
+				session.broadcastSave(requester)
+				continue
+			case event := <-session.event_chan:
+				if event != EVENT_RESUME && !sync_state() {
+					return false
+				}
+				session.broadcastEvent(event)
+				switch event {
+				case EVENT_PAUSE:
+					pause_flag = true
+				case EVENT_RESUME:
+					pause_flag = false
+				case EVENT_QUIT, EVENT_KILL:
+					store.clear(session.id)
+					return false
+				}
+				continue
+			default:
+			}
+			if !rebalance() || !balance_load() {
+				return false
+			}
+			if !pause_flag && (!held || session.turn < target) {
+				return true
+			}
+			select {
+			case request := <-session.step_chan:
+				step(request)
+			case <-time.After(pausePollInterval):
+			}
+		}
+	}
+
+	// Evaluate all turns (held sessions wait for Step first)
+	if held && !handle_events() {
+		return
+	}
+	for session.turn < session.bp.Turns {
+
+		if session.batching {
+			// Evaluate multiple turns per RPC and replay them turn by turn
+			depth := session.depth
+			if remaining := session.bp.Turns - session.turn; depth > remaining {
+				depth = remaining
+			}
+			if remaining := target - session.turn; held && depth > remaining {
+				depth = remaining
+			}
+			replies, err := session.batch(assignments, depth)
+			if err != nil {
+				log.Print(err.Error())
+				if !recover_evaluation() {
+					return
+				}
+				continue
+			}
+			for i, assignment := range assignments {
+				session.measure(assignment, replies[i].Elapsed/time.Duration(depth))
+			}
+			for turn := 0; turn != depth; turn++ {
+				for _, reply := range replies {
+					flipped_data := reply.Flipped[turn]
+					session.broadcastCompressedFlipped(flipped_data)
+					for _, cell := range decompressFlipped(flipped_data, session.bp.SizeInt) {
+						session.matrix.flip(cell)
+					}
+				}
+				session.turn++
+				session.synced++
+				session.broadcastEvent(EVENT_TURN_COMPLETE)
+				if store.due(session.turn) {
+					store.save(session.bp, &session.matrix, session.turn)
+				}
+			}
+		} else {
+			// Instruct worker nodes to evaluate next turn
+			calls := make(map[*rpc.Call]Node, len(assignments))
+			for i, assignment := range assignments {
+				replies[i] = new(NextReply)
+				calls[assignment.Node.client.Go("Worker.Next", adjustment_buffers[i], replies[i], call_chan)] = assignment.Node
+			}
+
+			// Clear adjustment buffers
+			for i := range assignments {
+				adjustment_buffers[i].Increment = adjustment_buffers[i].Increment[0:0]
+				adjustment_buffers[i].Decrement = adjustment_buffers[i].Decrement[0:0]
+			}
+
+			// Check if all RPC calls succeeded
+			if err := session.await(calls, call_chan, nil); err != nil {
+				log.Print(err.Error())
+				// Evaluate the turn again on worker nodes left
+				if !recover_evaluation() {
+					return
+				}
+				continue
+			}
+
+			// Apply flipping results (exchanged between worker nodes in direct mode)
+			for i, assignment := range assignments {
+				session.measure(assignment, replies[i].Elapsed)
+			}
+			if !session.bp.Direct {
+				for i := range assignments {
+					flipped_data := replies[i].Flipped
+					flipped := decompressFlipped(flipped_data, session.bp.SizeInt)
+					session.broadcastCompressedFlipped(flipped_data)
+					session.updateMatrixAndGetAdjustments(flipped, adjustment_buffers)
+				}
+				session.synced++
+			}
+			session.turn++
+			session.broadcastEvent(EVENT_TURN_COMPLETE)
+			if session.bp.Direct && (session.turn == session.bp.Turns || (held && session.turn == target) ||
+				(session.bp.SyncEvery > 0 && session.turn%session.bp.SyncEvery == 0) ||
+				store.due(session.turn) || time.Since(last_sync) >= syncInterval) {
+				if !sync_state() {
+					return
+				}
+				if session.synced != session.turn {
+					continue // Evaluating again from last collection
+				}
+			}
+			if store.due(session.turn) {
+				store.save(session.bp, &session.matrix, session.turn)
+			}
+		}
+		if !handle_events() {
+			return
+		}
+	}
+
+	// Keep durable state if no local controller received the result
+	if len(session.conns) != 0 {
+		store.clear(session.id)
+	}
+}
+
+// Set cells of a region to the same state and stream cells flipped to local controllers
+func (session *Session) edit(args EditArgs) error {
+	if args.Start.X < 0 || args.Start.Y < 0 || args.End.X > session.matrix.width ||
+		args.End.Y > session.matrix.height || args.Start.X > args.End.X || args.Start.Y > args.End.Y {
+		return errors.New("region outside of matrix")
+	}
+	flipped := make([]Cell, 0, 1024)
+	for y := args.Start.Y; y != args.End.Y; y++ {
+		for x := args.Start.X; x != args.End.X; x++ {
+			if (session.matrix.pixels[y][x] != 0) != args.Alive {
+				session.matrix.flip(Cell{X: x, Y: y})
+				flipped = append(flipped, Cell{X: x, Y: y})
+			}
+		}
+	}
+	session.broadcastCompressedFlipped(compressFlipped(flipped, session.bp.SizeInt))
+	session.broadcastEvent(EVENT_SYNC)
+	return nil
+}
+
+// Attach connection of local controller at the end of current turn and reply current state
+func (session *Session) attach(request AttachRequest, paused bool) {
+	bp := session.bp
+	bp.Turn = session.turn
+	bp.Pixels = packMatrix(&session.matrix)
+	bp.Initials = nil
+	if request.conn != nil {
+		session.conns = append(session.conns, request.conn)
+		log.Printf("Connection to %s attached to session %s at turn %d",
+			request.conn.conn.RemoteAddr().String(), session.id, session.turn)
+		if paused && request.conn.writeEvent(EVENT_PAUSE) != nil {
+			session.conns = session.conns[:len(session.conns)-1]
+			request.conn.conn.Close()
+		}
+	}
+	request.reply <- &bp
+}
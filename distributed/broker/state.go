@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Magic bytes at the start of every durable state file
+const stateMagic = "GOLBRKS1"
+
+// Store keeping the last durable state of every running session on local disk (one file per session)
+// Snapshots are written by a background goroutine so that evaluation is not blocked by disk
+type StateStore struct {
+	dir      string            // Directory of state files (durable state disabled when empty)
+	interval int               // Number of turns between snapshots
+	requests chan stateRequest // Pending snapshots to be written
+}
+
+// Request to the writing goroutine of state store
+type stateRequest struct {
+	session string
+	bp      *BrokerParams // Snapshot to be written (nil to remove state file of session)
+	done    chan struct{} // Notified when state file is removed
+}
+
+// Create state store and start writing goroutine
+func NewStateStore(dir string, interval int) *StateStore {
+	store := &StateStore{
+		dir:      dir,
+		interval: interval,
+		requests: make(chan stateRequest, 16),
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			log.Panic(err.Error())
+		}
+		go store.writer()
+	}
+	return store
+}
+
+// Check if a snapshot should be taken after given number of completed turns
+func (store *StateStore) due(turn int) bool {
+	return store != nil && store.dir != "" && store.interval > 0 && turn%store.interval == 0
+}
+
+// Queue snapshot of matrix of a session after given number of completed turns
+// Snapshot is dropped if too many snapshots are still being written
+func (store *StateStore) save(bp BrokerParams, matrix *Matrix, turn int) {
+	if store == nil || store.dir == "" {
+		return
+	}
+	bp.Turn = turn
+	bp.Pixels = packMatrix(matrix)
+	bp.Initials = nil
+	select {
+	case store.requests <- stateRequest{session: bp.Session, bp: &bp}:
+	default:
+		log.Printf("Snapshot of session %s turn %d skipped: previous snapshots still being written", bp.Session, turn)
+	}
+}
+
+// Remove state file of a session after evaluation finished or stopped by local controller
+func (store *StateStore) clear(session string) {
+	if store == nil || store.dir == "" {
+		return
+	}
+	done := make(chan struct{})
+	store.requests <- stateRequest{session: session, done: done}
+	<-done
+}
+
+// Load last durable state of a session
+func (store *StateStore) load(session string) (BrokerParams, error) {
+	var bp BrokerParams
+	if store == nil || store.dir == "" {
+		return bp, errors.New("durable state is disabled")
+	}
+	path := store.path(session)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bp, errors.New("no durable state of session " + session)
+		}
+		return bp, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic := make([]byte, len(stateMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != stateMagic {
+		return bp, fmt.Errorf("%s is not a broker state file", path)
+	}
+	if err = gob.NewDecoder(reader).Decode(&bp); err != nil {
+		return bp, fmt.Errorf("broker state %s: %w", path, err)
+	}
+	if len(bp.Pixels) != bp.ImageWidth*bp.ImageHeight/8+1 {
+		return bp, fmt.Errorf("broker state %s: pixel data does not match image size", path)
+	}
+	return bp, nil
+}
+
+// List sessions with durable state (least recently written first)
+func (store *StateStore) list() []string {
+	if store == nil || store.dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil
+	}
+	sessions := make([]string, 0, len(entries))
+	times := make(map[string]int64)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".state") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		session := strings.TrimSuffix(entry.Name(), ".state")
+		if !validSessionID(session) {
+			continue
+		}
+		sessions = append(sessions, session)
+		times[session] = info.ModTime().UnixNano()
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return times[sessions[i]] < times[sessions[j]]
+	})
+	return sessions
+}
+
+// Path of state file of a session
+func (store *StateStore) path(session string) string {
+	return filepath.Join(store.dir, session+".state")
+}
+
+// Write queued snapshots until broker exits
+func (store *StateStore) writer() {
+	for request := range store.requests {
+		path := store.path(request.session)
+		if request.bp == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Print(err.Error())
+			}
+			request.done <- struct{}{}
+			continue
+		}
+		if err := writeState(path, request.bp); err != nil {
+			log.Printf("Snapshot of session %s turn %d failed: %s", request.session, request.bp.Turn, err.Error())
+			continue
+		}
+		log.Printf("Snapshot of session %s turn %d written", request.session, request.bp.Turn)
+	}
+}
+
+// Write state file (written to a temporary file first so an interrupted write keeps the last snapshot)
+func writeState(path string, bp *BrokerParams) error {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	_, err = writer.WriteString(stateMagic)
+	if err == nil {
+		err = gob.NewEncoder(writer).Encode(bp)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Pack pixels of matrix into bits in the layout of compressed pixel data
+func packMatrix(matrix *Matrix) []byte {
+	packed := make([]byte, matrix.width*matrix.height/8+1)
+	for y := 0; y != matrix.height; y++ {
+		for x := 0; x != matrix.width; x++ {
+			if matrix.pixels[y][x] != 0 {
+				i := y*matrix.width + x
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+	return packed
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSessionID tests that session IDs not generated by the broker are rejected before naming state files.
+func TestSessionID(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside.state")
+	if err := os.WriteFile(outside, []byte(stateMagic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broker := NewBroker(NewStateStore(filepath.Join(dir, "state"), 0))
+
+	if id := newSessionID(); !validSessionID(id) {
+		t.Errorf("Expected generated session ID %q to be valid", id)
+	}
+	for _, id := range []string{"../outside", "../../outside", "0123456789ABCDEF", "0123456789abcde", "0123456789abcdef0", "0123456789abcdeg"} {
+		t.Run(id, func(t *testing.T) {
+			if validSessionID(id) {
+				t.Fatalf("Expected session ID %q to be invalid", id)
+			}
+			if err := broker.Restore(SessionArgs{Session: id}, new(BrokerParams)); err == nil || !strings.Contains(err.Error(), "invalid session ID") {
+				t.Errorf("Expected Restore to reject session ID, got %v", err)
+			}
+			if _, err := broker.register(BrokerParams{Session: id, ImageWidth: 16, ImageHeight: 16}); err == nil {
+				t.Errorf("Expected session ID to be rejected on registration")
+			}
+			if err := broker.Kill(SessionArgs{Session: id}, nil); err == nil || !strings.Contains(err.Error(), "invalid session ID") {
+				t.Errorf("Expected Kill to reject session ID, got %v", err)
+			}
+		})
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("Expected state file outside of state directory to be left, got %v", err)
+	}
+}
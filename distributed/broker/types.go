@@ -5,6 +5,7 @@ package main
 import (
 	"net"
 	"net/rpc"
+	"time"
 )
 
 type Cell struct {
@@ -20,25 +21,83 @@ type Partition []Block // A set of blocks
 
 // Structure representing a worker node
 type Node struct {
-	ip     string // Private IP address
-	conn   *net.TCPConn
-	client *rpc.Client
+	ip       string // Private IP address
+	addr     string // Address of RPC service (dialed by peers in direct mode)
+	conn     *net.TCPConn
+	client   *rpc.Client
+	cores    int     // Number of CPUs usable by worker
+	capacity float64 // Cells evaluated per second reported on registration (number of cores if not measured)
 }
 
+// Message sent by worker node when registering to broker
+type Registration struct {
+	RPCPort int     // Port of worker RPC service
+	Cores   int     // Number of CPUs usable by worker
+	Speed   float64 // Cells evaluated per second by one core (measured on start)
+}
+
+// Bytes written by worker node on registration connection
+const (
+	DRAIN_REQUEST  = 1 // Request to be drained (written back by broker once no session uses the node)
+	HEARTBEAT      = 2 // Written periodically to show the node is alive
+	DRAIN_REJECTED = 3 // Written by broker when the node is the last one left to a running session
+)
+
 // Structure that binds a partition with a worker node
 type AssignedPartition struct {
 	Node      Node
 	Partition Partition
 }
 
+// Life-like rule in B/S notation (bit n set if n alive neighbours give birth or survival)
+type Rule struct {
+	Birth   uint16
+	Survive uint16
+}
+
 type BrokerParams struct {
+	Session     string // ID of session (issued by Init when empty)
+	Connection  uint64 // ID of streaming connection of local controller issued on connect
 	Turns       int
+	Turn        int // Number of completed turns to start from (non-zero when resuming from a checkpoint)
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
-	Pixels      []byte // Compressed pixel data
-	Initials    []byte // Compressed positions of initial alive cells (used when Pixels is nil)
-	SizeInt     int    // Minimum number of bytes to represent the whole range of width and height
+	Rule        Rule     // Life-like rule
+	Topology    Topology // Boundary condition at the edges of the grid
+	Pixels      []byte   // Compressed pixel data
+	Initials    []byte   // Compressed positions of initial alive cells (used when Pixels is nil)
+	SizeInt     int      // Minimum number of bytes to represent the whole range of width and height
+	Direct      bool     // Worker nodes exchange boundary flips directly (state collected by broker only when needed)
+	Batch       int      // Number of turns per RPC to worker nodes (chosen by broker from round-trip time when zero)
+	Held        bool     // Session only evaluates turns requested by Step
+	SyncEvery   int      // State is also collected at multiples of this number of turns in direct mode (automatic snapshots)
+}
+
+// Arguments of requests to a running session
+type SessionArgs struct {
+	Session    string // ID of session issued by Init
+	Connection uint64 // ID of streaming connection to attach (zero to get state only)
+}
+
+// Arguments of saving the state of a running session
+type SaveArgs struct {
+	Session   string // ID of session issued by Init
+	Requester uint64 // ID of streaming connection of local controller taking an automatic snapshot (zero when saved by the user)
+}
+
+// Arguments of evaluating turns of a running session and holding it after them
+type StepArgs struct {
+	Session string // ID of session issued by Init
+	Turns   int    // Number of turns to evaluate
+}
+
+// Arguments of setting all cells of a region of a running session to the same state
+type EditArgs struct {
+	Session string // ID of session issued by Init
+	Start   Cell   // Top-left corner of region
+	End     Cell   // Bottom-right corner of region (not inclusive)
+	Alive   bool   // State cells of region are set to
 }
 
 type WorkerParams struct {
@@ -46,10 +105,31 @@ type WorkerParams struct {
 	Threads           int
 	ImageWidth        int
 	ImageHeight       int
-	Pixels            [][]uint8 // Incomplete 2D slice storing pixels
-	SurroundingCounts [][]int8  // Incomplete 2D slice storing surrounding counts
-	Partition         Partition // Assigned task partition
-	SizeInt           int       // Minimum number of bytes to represent the whole range of width and height
+	Rule              Rule          // Life-like rule
+	Topology          Topology      // Boundary condition at the edges of the grid
+	Pixels            [][]uint8     // Incomplete 2D slice storing pixels
+	SurroundingCounts [][]int8      // Incomplete 2D slice storing surrounding counts
+	Partition         Partition     // Assigned task partition
+	SizeInt           int           // Minimum number of bytes to represent the whole range of width and height
+	Direct            bool          // Send boundary flips to peers directly instead of returning them to broker
+	Index             int           // Index of assigned partition in peers
+	Peers             []Peer        // Partitions of all worker nodes of the session (used in direct mode)
+	Generation        uint64        // ID of this assignment (exchanges from other assignments are rejected)
+	MaxBatch          int           // Depth of ghost zone in batch mode (one turn per RPC when less than two)
+	Timeout           time.Duration // Time peers may take to receive boundary flips in direct mode
+}
+
+// Partition evaluated by another worker node of the same session
+type Peer struct {
+	Address   string // Address of RPC service of worker node
+	Partition Partition
+}
+
+// Boundary flips of one turn sent directly from a worker node to a peer
+type Halo struct {
+	Generation uint64
+	Turn       int // Number of turns completed by sender since assigned
+	Adjustment Adjustment
 }
 
 // Slice of cells flipped that is used to adjust surrounding counts in other partitions
@@ -57,3 +137,21 @@ type Adjustment struct {
 	Increment []Cell // Surrounding counts of surrounding cells in the slice should be incremented
 	Decrement []Cell // Surrounding counts of surrounding cells in the slice should be decremented
 }
+
+// Result of evaluating one turn
+type NextReply struct {
+	Flipped []byte        // Compressed flipped cells (nil in direct mode)
+	Elapsed time.Duration // Time spent evaluating (used to weight partitions)
+}
+
+// Request of evaluating multiple turns in one RPC (batch mode)
+type BatchArgs struct {
+	Turns int    // Number of turns to evaluate (not deeper than ghost zone)
+	Ghost []byte // Compressed positions of alive cells in ghost zone up to the depth of number of turns
+}
+
+// Cells in partition flipped in each turn of a batch
+type BatchReply struct {
+	Flipped [][]byte      // Compressed flipped cells of each turn
+	Elapsed time.Duration // Time spent evaluating (subtracted from call time to measure round-trip time)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCheckpoint tests a 64x64 image run for 50 turns writing checkpoints, then resumed from turn 25 up to turn 100.
+func TestCheckpoint(t *testing.T) {
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 50, CheckpointInterval: 25}
+	expectedAlive := readAliveCells("check/images/64x64x100.pgm", p.ImageWidth, p.ImageHeight)
+	for _, threads := range []int{1, 8} {
+		p.Threads = threads
+		t.Run(fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads), func(t *testing.T) {
+			events := make(chan gol.Event)
+			go gol.Run(p, events, nil)
+			checkpoints := 0
+			for event := range events {
+				switch event.(type) {
+				case gol.CheckpointComplete:
+					checkpoints++
+				}
+			}
+			if checkpoints != 2 {
+				t.Errorf("Expected 2 checkpoints, got %v", checkpoints)
+			}
+
+			path := fmt.Sprintf("out/%dx%dx25.checkpoint", p.ImageWidth, p.ImageHeight)
+			checkpoint, err := gol.ReadCheckpoint(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkpoint.Turn != 25 {
+				t.Fatalf("Expected checkpoint at turn 25, got %v", checkpoint.Turn)
+			}
+
+			resumed := p
+			resumed.Turns = 100
+			resumed.CheckpointInterval = 0
+			resumed.Resume = path
+			events = make(chan gol.Event)
+			go gol.Run(resumed, events, nil)
+			var cells []util.Cell
+			for event := range events {
+				switch e := event.(type) {
+				case gol.StateChange:
+					if e.NewState == gol.Executing && e.CompletedTurns != 25 {
+						t.Errorf("Expected resumed run to start at turn 25, got %v", e.CompletedTurns)
+					}
+				case gol.FinalTurnComplete:
+					cells = e.Alive
+				}
+			}
+			assertEqualBoard(t, cells, expectedAlive, resumed)
+		})
+	}
+}
+
+// TestCheckpointParams tests resuming a HighLife run on a grid with dead borders through the library
+// without giving size, rule or topology, which are taken from the checkpoint.
+func TestCheckpointParams(t *testing.T) {
+	rule, err := gol.ParseRule("B36/S23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 20, Threads: 4, Rule: rule, Topology: gol.DeadBorder, CheckpointInterval: 10}
+	p.Output = filepath.Join(t.TempDir(), "{turn}")
+	for event := range runEvents(p) {
+		if e, ok := event.(gol.ErrorEvent); ok {
+			t.Fatalf("Unexpected failure %v", e.Err)
+		}
+	}
+
+	resumed := gol.Params{Turns: 20, Threads: 4, Resume: filepath.Join(filepath.Dir(p.Output), "10.checkpoint")}
+	resumed.Output = p.Output
+	var cells []util.Cell
+	for event := range runEvents(resumed) {
+		switch e := event.(type) {
+		case gol.ErrorEvent:
+			t.Fatalf("Unexpected failure %v", e.Err)
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		}
+	}
+	assertEqualBoard(t, cells, referenceTurns(readAliveCells("images/64x64.pgm", 64, 64), p), p)
+
+	// Values set explicitly must match those of checkpoint
+	conflicting := []gol.Params{resumed, resumed, resumed}
+	conflicting[0].ImageWidth, conflicting[0].ImageHeight = 16, 16
+	conflicting[1].Rule = gol.Conway
+	conflicting[2].Topology = gol.Cylinder
+	for _, c := range conflicting {
+		var inputError *gol.InputError
+		if err := gol.Run(c, make(chan gol.Event, 100), nil); !errors.As(err, &inputError) {
+			t.Errorf("Expected an InputError for parameters not matching checkpoint, got %v", err)
+		}
+	}
+}
+
+// Run with an events channel closed when the run ends
+func runEvents(p gol.Params) chan gol.Event {
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	return events
+}
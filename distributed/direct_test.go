@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestDirect tests 16x16, 64x64 and 512x512 images on 0, 1 and 100 turns with worker nodes exchanging boundary flips directly.
+func TestDirect(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16, Direct: true},
+		{ImageWidth: 64, ImageHeight: 64, Direct: true},
+		{ImageWidth: 512, ImageHeight: 512, Direct: true},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			expectedAlive := readAliveCells(
+				"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			for _, threads := range []int{1, 2, 4, 8, 12, 16} {
+				p.Threads = threads
+				testName := fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+				t.Run(testName, func(t *testing.T) {
+					events := make(chan gol.Event)
+					go gol.Run(p, events, nil)
+					var cells []util.Cell
+					for event := range events {
+						switch e := event.(type) {
+						case gol.FinalTurnComplete:
+							cells = e.Alive
+						}
+					}
+					assertEqualBoard(t, cells, expectedAlive, p)
+				})
+			}
+		}
+	}
+}
+
+// TestDirectAlive checks alive cell counts of 512x512 reported in direct mode are those of the turns they are reported at.
+func TestDirectAlive(t *testing.T) {
+	p := gol.Params{
+		Turns:       100000000,
+		Threads:     8,
+		ImageWidth:  512,
+		ImageHeight: 512,
+		Direct:      true,
+	}
+	alive := readAliveCounts(p.ImageWidth, p.ImageHeight)
+	events := make(chan gol.Event)
+	keyPresses := make(chan rune, 2)
+	go gol.Run(p, events, keyPresses)
+
+	timer := time.After(7 * time.Second)
+	counts := 0
+	for event := range events {
+		switch e := event.(type) {
+		case gol.AliveCellsCount:
+			if e.CompletedTurns > 10000 {
+				continue
+			}
+			if alive[e.CompletedTurns] != e.CellsCount {
+				t.Errorf("At turn %v expected %v alive cells, got %v instead", e.CompletedTurns, alive[e.CompletedTurns], e.CellsCount)
+			}
+			counts++
+		}
+		select {
+		case <-timer:
+			keyPresses <- 'q'
+			timer = nil
+		default:
+		}
+	}
+	if counts == 0 {
+		t.Error("No alive cell counts reported")
+	}
+}
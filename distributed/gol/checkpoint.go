@@ -0,0 +1,119 @@
+package gol
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Magic bytes at the start of every checkpoint file
+const checkpointMagic = "GOLCKPT1"
+
+// Checkpoint captures the state of a simulation so that it can be continued later.
+type Checkpoint struct {
+	Turn   int    // Number of completed turns
+	Params Params // Parameters of the run that wrote the checkpoint
+	Pixels []byte // Cells of the grid row by row, packed 8 cells per byte
+}
+
+// ReadCheckpoint reads a checkpoint file written by a previous run
+func ReadCheckpoint(path string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	file, err := os.Open(path)
+	if err != nil {
+		return checkpoint, &IOError{path, err}
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic := make([]byte, len(checkpointMagic))
+	if _, err = io.ReadFull(reader, magic); err != nil || string(magic) != checkpointMagic {
+		return checkpoint, &InputError{path, errors.New("not a checkpoint file")}
+	}
+	if err = gob.NewDecoder(reader).Decode(&checkpoint); err != nil {
+		return checkpoint, &InputError{path, fmt.Errorf("checkpoint: %w", err)}
+	}
+	if len(checkpoint.Pixels) != (checkpoint.Params.ImageWidth*checkpoint.Params.ImageHeight+7)/8 {
+		return checkpoint, &InputError{path, errors.New("pixel data does not match image size")}
+	}
+	return checkpoint, nil
+}
+
+// Take size, rule and topology of the run that wrote a checkpoint
+// Values already set in p must match (zero size, rule not given and torus topology are taken as unset)
+func (p *Params) resumeFrom(saved Params) error {
+	saved.defaultRule()
+	if (p.ImageWidth != 0 && p.ImageWidth != saved.ImageWidth) || (p.ImageHeight != 0 && p.ImageHeight != saved.ImageHeight) {
+		return fmt.Errorf("image size %dx%d does not match %dx%d of checkpoint",
+			p.ImageWidth, p.ImageHeight, saved.ImageWidth, saved.ImageHeight)
+	}
+	if p.ruleGiven() && p.Rule != saved.Rule {
+		return fmt.Errorf("rule %v does not match %v of checkpoint", p.Rule, saved.Rule)
+	}
+	if p.Topology != Torus && p.Topology != saved.Topology {
+		return fmt.Errorf("topology %v does not match %v of checkpoint", p.Topology, saved.Topology)
+	}
+	p.ImageWidth, p.ImageHeight = saved.ImageWidth, saved.ImageHeight
+	p.Rule, p.RuleSet, p.Topology = saved.Rule, true, saved.Topology
+	return nil
+}
+
+// Write checkpoint file (written to a temporary file first so an interrupted write keeps the last checkpoint)
+func writeCheckpoint(path string, checkpoint Checkpoint) error {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	_, err = writer.WriteString(checkpointMagic)
+	if err == nil {
+		err = gob.NewEncoder(writer).Encode(checkpoint)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Pack pixels into bits (8 cells per byte)
+func packCells(pixels []uint8) []byte {
+	packed := make([]byte, (len(pixels)+7)/8)
+	packBits(packed, pixels, false)
+	return packed
+}
+
+// Set bits of alive pixels in packed data, least significant bit first (most significant first for bitmap images)
+func packBits(packed []byte, pixels []uint8, msb_first bool) {
+	for i, pixel := range pixels {
+		if pixel == 0 {
+			continue
+		}
+		if msb_first {
+			packed[i/8] |= 0x80 >> (i % 8)
+		} else {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+}
+
+// Unpack bits into pixels of given count
+func unpackCells(packed []byte, count int) []uint8 {
+	pixels := make([]uint8, count)
+	for i := range pixels {
+		if packed[i/8]&(1<<(i%8)) != 0 {
+			pixels[i] = 255
+		}
+	}
+	return pixels
+}
@@ -19,9 +19,7 @@ func compressMatrix(bp *BrokerParams, pixels []uint8, initials []util.Cell) {
 	if size_pixels <= size_initials {
 		// Compressed pixel data will be sent
 		bp.Pixels = make([]byte, size_pixels)
-		for i := range pixels {
-			bp.Pixels[i/8] |= pixels[i] & (1 << (i % 8))
-		}
+		packBits(bp.Pixels, pixels, false)
 	} else {
 		// Compressed position of initial alive cells will be sent
 		bp.Initials = make([]byte, size_initials)
@@ -1,12 +1,9 @@
 package gol
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"net/rpc"
 	"time"
-
-	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type distributorChannels struct {
@@ -14,174 +11,160 @@ type distributorChannels struct {
 	keyPresses <-chan rune
 }
 
-// Create RPC client
-var client = func() *rpc.Client {
-	c, err := rpc.DialHTTP("tcp", "54.209.41.143:2000")
-	if err != nil {
-		log.Panic(err.Error())
-	}
-	log.Print("RPC Server 54.209.41.143 connected")
-	return c
-}()
-
 // distributor divides the work between workers and interacts with other goroutines.
-func distributor(p Params, io *ioState, c distributorChannels) {
+// Return the first failure reported to the user, after events channel is closed
+func distributor(ctx context.Context, p Params, io *ioState, c distributorChannels) error {
 
 	defer io.quit()
 
-	// Start Reading file
+	// Start Reading file (or checkpoint when resuming, or pattern, or nothing when state is taken from broker)
 	operation := ioOperation{
 		command:  ioInput,
-		filename: fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight),
+		filename: fmt.Sprintf("images/%dx%d.pgm", p.ImageWidth, p.ImageHeight),
 	}
-	io.sendIoRequest(&operation)
-
-	// Establish connection for data streaming
-	size_int := getSizeOfInt(p.ImageWidth, p.ImageHeight)
-	conn := NewConnection(size_int)
-
-	// Wait for pending read request
-	io.waitIoRequest()
-
-	// Keep a local copy of pixel matrix
-	count := 0
-	matrix := make([][]uint8, p.ImageHeight)
-	flipping_buffer := make([]util.Cell, 0, 1024)
-	for y := 0; y != p.ImageHeight; y++ {
-		matrix[y] = operation.data[y*p.ImageWidth : (y+1)*p.ImageWidth]
-		for x := 0; x != p.ImageWidth; x++ {
-			if matrix[y][x] != 0 {
-				count++
-				flipping_buffer = append(flipping_buffer, util.Cell{X: x, Y: y})
-			}
+	if p.Input != "" {
+		operation.filename = p.Input
+	}
+	if p.Resume != "" {
+		operation = ioOperation{
+			command:  ioCheckpointInput,
+			filename: p.Resume,
+		}
+	} else if p.Pattern != "" {
+		operation = ioOperation{
+			command:  ioPatternInput,
+			filename: p.Pattern,
 		}
 	}
-
-	// Prepare task
-	var reply struct{}
-	bp := BrokerParams{
-		Turns:       p.Turns,
-		Threads:     p.Threads,
-		ImageWidth:  p.ImageWidth,
-		ImageHeight: p.ImageHeight,
+	if p.remote == nil {
+		io.sendIoRequest(&operation)
+		if err := io.waitIoRequest(&operation); err != nil {
+			return abort(c.events, 0, err)
+		}
 	}
-	compressMatrix(&bp, operation.data, flipping_buffer)
-	err := client.Call("Broker.Init", bp, &reply)
+
+	// Start session on broker (or attach to running session)
+	notices := make(chan notice)
+	sim, err := startSimulator(p, operation.data, operation.turn, c.events, notices)
 	if err != nil {
-		log.Panic(err.Error())
+		return abort(c.events, operation.turn, err)
 	}
-	log.Printf("Broker.Init: %dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
-
-	// Write file function
-	write := func(turn int) {
-		filename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
-		operation := &ioOperation{
-			command:  ioOutput,
-			filename: filename,
-			data:     matrix[0][0 : p.ImageWidth*p.ImageHeight],
+
+	// Failures of writing files and of the session are reported to the user
+	var failure error
+	report := func(turn int, err error) {
+		if failure == nil {
+			failure = err
 		}
-		io.sendIoRequest(operation)
-		io.waitIoRequest()
-		c.events <- ImageOutputComplete{turn, filename}
+		c.events <- ErrorEvent{turn, err}
+	}
+
+	// Snapshots are written in the background so that session events keep being handled
+	snapshots := &snapshotWriter{
+		io:      io,
+		events:  c.events,
+		report:  report,
+		started: time.Now(),
+		keep:    p.SnapshotKeep,
+	}
+
+	// Timer of automatic snapshots (never ready when disabled)
+	// Snapshots of the timer are saved through the broker so that the state of a turn is consistent
+	// (those of the interval are taken by the simulator at the turn due)
+	var snapshot_timer <-chan time.Time
+	if p.SnapshotPeriod > 0 {
+		snapshot_ticker := time.NewTicker(p.SnapshotPeriod)
+		defer snapshot_ticker.Stop()
+		snapshot_timer = snapshot_ticker.C
 	}
 
 	// Alive timer
 	ticker := time.NewTicker(time.Second * 2)
 	defer ticker.Stop()
 
-	// Handle events
-	turn := 0
-	uncomfirmed_count := count
-	pause_flag := false
-	c.events <- CellsFlipped{0, flipping_buffer}
-	c.events <- StateChange{turn, Executing}
-	for turn != p.Turns {
+	// Handle events until simulator stops following the session
+	// Cancelling quits the session like 'q' (channel is cleared so that it is handled once)
+	done := ctx.Done()
+	for {
 		select {
+		case <-done:
+			done = nil
+			if p.Session != "" || sim.Quit() != nil {
+				goto quit
+			}
 		case <-ticker.C:
+			turn, count := sim.counted()
 			c.events <- AliveCellsCount{turn, count}
+		case <-snapshot_timer:
+			if !sim.Paused() {
+				sim.autosave()
+			}
 		case char := <-c.keyPresses:
 			switch char {
 			case 's':
-				err = client.Call("Broker.Save", struct{}{}, &struct{}{})
-				if err != nil {
-					log.Panic(err.Error())
-				}
+				sim.Save()
 			case 'q':
-				err = client.Call("Broker.Quit", struct{}{}, &struct{}{})
-				if err != nil {
-					log.Panic(err.Error())
+				if p.Session != "" {
+					// Detach from session of another controller
+					goto quit
+				}
+				if sim.Quit() != nil {
+					goto quit
 				}
 			case 'p':
-				pause_flag = !pause_flag
-				if pause_flag {
-					err = client.Call("Broker.Pause", struct{}{}, &struct{}{})
-					if err != nil {
-						log.Panic(err.Error())
-					}
+				if sim.Paused() {
+					sim.Resume()
 				} else {
-					err = client.Call("Broker.Resume", struct{}{}, &struct{}{})
-					if err != nil {
-						log.Panic(err.Error())
-					}
+					sim.Pause()
 				}
 			case 'k':
-				err = client.Call("Broker.Kill", struct{}{}, &struct{}{})
-				if err != nil {
-					log.Panic(err.Error())
-				}
-			}
-		case flipped := <-conn.result_chan:
-			for _, cell := range flipped {
-				if matrix[cell.Y][cell.X] == 0 {
-					matrix[cell.Y][cell.X] = 255
-					uncomfirmed_count++
-				} else {
-					matrix[cell.Y][cell.X] = 0
-					uncomfirmed_count--
+				if sim.Kill() != nil {
+					goto quit
 				}
 			}
-			c.events <- CellsFlipped{turn, flipped}
-		case event := <-conn.event_chan:
-			switch event {
-			case EVENT_TURN_COMPLETE:
-				c.events <- TurnComplete{turn}
-				count = uncomfirmed_count
-				turn++
-				log.Printf("Turn result [%d] collected", turn)
-			case EVENT_RESUME:
-				c.events <- StateChange{turn, Executing}
-				log.Print("Continuing")
-			case EVENT_PAUSE:
-				c.events <- StateChange{turn, Paused}
-			case EVENT_SAVE:
-				write(turn)
-			case EVENT_KILL:
-				log.Print("Remote system shutdowns")
-				fallthrough
-			case EVENT_QUIT:
+		case n, ok := <-notices:
+			if !ok {
 				goto quit
 			}
+			if n.auto {
+				snapshots.autosave(n.turn, n.pixels)
+			} else {
+				snapshots.save(n.turn, n.pixels, n.save, true)
+			}
 		}
+		snapshots.poll()
 	}
 
 quit:
-	cells := make([]util.Cell, count)
-	cells_index := 0
-	for i := 0; i != p.ImageHeight; i++ {
-		for j := 0; j != p.ImageWidth; j++ {
-			if matrix[i][j] != 0 {
-				cells[cells_index] = util.Cell{X: j, Y: i}
-				cells_index++
-			}
-		}
+	sim.Close()
+	turn := sim.Turn()
+	if err := sim.Err(); err != nil {
+		report(turn, err)
 	}
-	c.events <- FinalTurnComplete{turn, cells}
+	c.events <- FinalTurnComplete{turn, sim.AliveCells()}
 
-	// Write file
-	write(turn)
+	// Write file (skipped when cancelled unless requested)
+	cancelled := ctx.Err() != nil && turn < p.Turns
+	if !cancelled || p.SaveOnCancel {
+		// Keep a checkpoint if quitting before the last turn
+		snapshots.save(turn, sim.copyPixels(), true, turn < p.Turns)
+	}
+	snapshots.flush()
 
 	c.events <- StateChange{turn, Quitting}
 
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
 	close(c.events)
+	if failure == nil && cancelled {
+		return ctx.Err()
+	}
+	return failure
+}
+
+// Report a failure stopping the run before evaluation starts and close events channel
+func abort(events chan<- Event, turn int, err error) error {
+	events <- ErrorEvent{turn, err}
+	events <- StateChange{turn, Quitting}
+	close(events)
+	return err
 }
@@ -0,0 +1,48 @@
+package gol
+
+import "fmt"
+
+// InputError reports an input image, checkpoint or parameters that cannot be used for a run.
+type InputError struct {
+	Path string // File the input was read from (empty for parameters)
+	Err  error
+}
+
+// IOError reports a failure of reading or writing a file.
+type IOError struct {
+	Path string // File being read or written
+	Err  error
+}
+
+// RemoteError reports a failure of the broker or of the session running on it.
+type RemoteError struct {
+	Op  string // Call to the broker or stage of the session that failed
+	Err error
+}
+
+func (err *InputError) Error() string {
+	if err.Path == "" {
+		return "bad input: " + err.Err.Error()
+	}
+	return "bad input " + err.Path + ": " + err.Err.Error()
+}
+
+func (err *InputError) Unwrap() error {
+	return err.Err
+}
+
+func (err *IOError) Error() string {
+	return fmt.Sprintf("io failure on %s: %v", err.Path, err.Err)
+}
+
+func (err *IOError) Unwrap() error {
+	return err.Err
+}
+
+func (err *RemoteError) Error() string {
+	return "remote failure in " + err.Op + ": " + err.Err.Error()
+}
+
+func (err *RemoteError) Unwrap() error {
+	return err.Err
+}
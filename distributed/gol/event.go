@@ -29,6 +29,13 @@ type ImageOutputComplete struct { // implements Event
 	Filename       string
 }
 
+// `CheckpointComplete` is an Event notifying the user about the completion of a checkpoint.
+// This Event should be sent every time a checkpoint has been saved.
+type CheckpointComplete struct { // implements Event
+	CompletedTurns int
+	Filename       string
+}
+
 // State represents a change in the state of execution.
 type State int
 
@@ -45,6 +52,29 @@ type StateChange struct { // implements Event
 	NewState       State
 }
 
+// RecoveryStatus represents the progress of recovering from failed worker nodes.
+type RecoveryStatus int
+
+const (
+	Recovering RecoveryStatus = iota
+	Recovered
+	RecoveryFailed
+)
+
+// `RecoveryChange` is an Event notifying the user about recovery from failed worker nodes.
+// This Event is sent when the broker starts moving evaluation to other worker nodes and when it succeeds or fails.
+type RecoveryChange struct { // implements Event
+	CompletedTurns int
+	Status         RecoveryStatus
+}
+
+// `ErrorEvent` is an Event notifying the user about a failure of the run.
+// The run stops after this Event unless the failure only affects an output file.
+type ErrorEvent struct { // implements Event
+	CompletedTurns int
+	Err            error
+}
+
 // `CellFlipped` is an Event notifying the GUI about a change of state of a single cell.
 // This event should be sent every time a cell changes state.
 // Make sure to send this event for all cells that are alive when the image is loaded in.
@@ -101,6 +131,35 @@ func (event StateChange) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (status RecoveryStatus) String() string {
+	switch status {
+	case Recovering:
+		return "Recovering"
+	case Recovered:
+		return "Recovered"
+	case RecoveryFailed:
+		return "Recovery Failed"
+	default:
+		return "Incorrect Recovery Status"
+	}
+}
+
+func (event RecoveryChange) String() string {
+	return fmt.Sprintf("%v", event.Status)
+}
+
+func (event RecoveryChange) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event ErrorEvent) String() string {
+	return fmt.Sprintf("Error: %v", event.Err)
+}
+
+func (event ErrorEvent) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event AliveCellsCount) String() string {
 	return fmt.Sprintf("Alive Cells %v", event.CellsCount)
 }
@@ -117,6 +176,14 @@ func (event ImageOutputComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event CheckpointComplete) String() string {
+	return fmt.Sprintf("Checkpoint %v Output Done", event.Filename)
+}
+
+func (event CheckpointComplete) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event CellFlipped) String() string {
 	return ""
 }
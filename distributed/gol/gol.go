@@ -1,6 +1,15 @@
 package gol
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
 
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
@@ -8,21 +17,186 @@ type Params struct {
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Rule        Rule     // Life-like rule (Conway's Game of Life when zero unless RuleSet)
+	RuleSet     bool     // Rule is given even when zero (B/S, without births or survivals)
+	Topology    Topology // Boundary condition at the edges of the grid
+	Config      *Config  // Addresses of remote processes (loaded from config file and environment when nil)
+
+	Resume             string // Checkpoint file to continue from (input image is loaded when empty)
+	CheckpointInterval int    // Write a checkpoint every given number of turns (disabled when zero)
+	Restore            bool   // Continue the interrupted run kept in durable state of broker
+	Session            string // ID of running session to attach to (watching the run of another controller)
+	Direct             bool   // Worker nodes exchange boundary flips directly instead of through the broker
+	Batch              int    // Number of turns per RPC to worker nodes (chosen by broker from round-trip time when zero)
+	SaveOnCancel       bool   // Write final image and checkpoint when the run is cancelled through its context
+
+	SnapshotInterval int           // Write an image and a checkpoint every given number of turns (disabled when zero)
+	SnapshotPeriod   time.Duration // Write an image and a checkpoint every given duration (disabled when zero)
+	SnapshotKeep     int           // Number of latest automatic snapshots whose files are kept (all when zero)
+
+	Input         string    // Input netpbm image path (images/<width>x<height>.pgm when empty)
+	Output        string    // Output path template without extension: {w}, {h}, {turn} and {time} are replaced (out/<width>x<height>x<turn> when empty)
+	Pattern       string    // RLE (.rle) or plaintext (.cells) pattern file loaded instead of input image
+	PatternOffset util.Cell // Position of the top-left corner of the pattern in the image
+	OutputFormat  string    // Format of output images: "pgm" (default), "pbm" (bit-packed), "rle" or "cells"
+
+	remote *BrokerParams // State of run fetched from broker
+	held   bool          // Session only evaluates turns requested by Step
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
-func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+// Failures are sent as an ErrorEvent and the first of them is returned once events channel is closed.
+func Run(p Params, events chan<- Event, keyPresses <-chan rune) error {
+	return RunContext(context.Background(), p, events, keyPresses)
+}
+
+// RunContext is Run quitting the session when ctx is cancelled or its deadline passes (detaching when watching).
+// FinalTurnComplete and Quitting are sent as when quitting with 'q', and ctx.Err() is returned
+// unless the run failed. Final image and checkpoint are written only if p.SaveOnCancel is set.
+func RunContext(ctx context.Context, p Params, events chan<- Event, keyPresses <-chan rune) error {
+
+	// Size, rule and topology are taken from the header of checkpoint
+	if p.Resume != "" && !p.Restore && p.Session == "" {
+		checkpoint, err := ReadCheckpoint(p.Resume)
+		if err != nil {
+			return abort(events, 0, err)
+		}
+		if err := p.resumeFrom(checkpoint.Params); err != nil {
+			return abort(events, 0, &InputError{p.Resume, err})
+		}
+	}
+
+	// Rule given in the header of pattern applies unless set
+	if !p.ruleGiven() && p.Pattern != "" && p.Resume == "" {
+		if pattern, err := ReadPattern(p.Pattern); err == nil {
+			p.Rule, p.RuleSet = pattern.Rule, pattern.RuleSet
+		}
+	}
+	p.defaultRule()
+
+	switch p.OutputFormat {
+	case "", "pgm", "pbm", "rle", "cells":
+	default:
+		return abort(events, 0, &InputError{"", fmt.Errorf("unknown output format %q", p.OutputFormat)})
+	}
+
+	if p.SnapshotInterval < 0 || p.SnapshotPeriod < 0 || p.SnapshotKeep < 0 {
+		return abort(events, 0, &InputError{"", errors.New("snapshot interval, period and number kept must not be negative")})
+	}
+
+	if p.Config == nil {
+		config, err := LoadConfig("")
+		if err != nil {
+			return abort(events, 0, &InputError{"", err})
+		}
+		p.Config = &config
+	}
+
+	if p.Restore && p.remote == nil {
+		restored, err := Restore(p)
+		if err != nil {
+			return abort(events, 0, err)
+		}
+		p = restored
+	}
+
+	if p.Session != "" && p.remote == nil {
+		attached, err := Attach(p)
+		if err != nil {
+			return abort(events, 0, err)
+		}
+		p = attached
+	}
+
+	// Size of image is taken from the header of input image
+	if p.Input != "" && p.Resume == "" && p.Pattern == "" && p.remote == nil {
+		width, height, err := ReadImageSize(p.Input)
+		if err != nil {
+			return abort(events, 0, err)
+		}
+		p.ImageWidth, p.ImageHeight = width, height
+	}
+
+	if p.ImageWidth <= 0 || p.ImageHeight <= 0 {
+		return abort(events, 0, &InputError{"", errors.New("image size must be positive")})
+	}
 
 	io := &ioState{
 		params: p,
 		cond:   sync.NewCond(new(sync.Mutex)),
 	}
-	io.cond.L.Lock()
-	go io.startIo() // transfer ownership of lock to startIo
+	go io.startIo()
 
 	distributorChannels := distributorChannels{
 		events:     events,
 		keyPresses: keyPresses,
 	}
-	distributor(p, io, distributorChannels)
+	return distributor(ctx, p, io, distributorChannels)
+}
+
+// Restore fetches durable state of the most recently interrupted session from the broker.
+// Size, rule, topology and number of turns of returned parameters are taken from the broker.
+func Restore(p Params) (Params, error) {
+	return fetchState(p, "Broker.Restore", SessionArgs{})
+}
+
+// Attach fetches state of the running session p.Session from the broker so that it can be watched.
+// Size, rule, topology and number of turns of returned parameters are taken from the broker.
+func Attach(p Params) (Params, error) {
+	return fetchState(p, "Broker.Attach", SessionArgs{Session: p.Session})
+}
+
+// Shutdown stops every session on the broker of p.Config and shuts down the broker and its worker nodes.
+func Shutdown(p Params) error {
+	if p.Config == nil {
+		config, err := LoadConfig("")
+		if err != nil {
+			return &InputError{"", err}
+		}
+		p.Config = &config
+	}
+
+	client, err := rpc.DialHTTP("tcp", p.Config.rpcAddr())
+	if err != nil {
+		return &RemoteError{"dial", err}
+	}
+	defer client.Close()
+
+	if err = client.Call("Broker.Shutdown", struct{}{}, &struct{}{}); err != nil {
+		return &RemoteError{"Broker.Shutdown", err}
+	}
+	return nil
+}
+
+// Fetch state of a run from the broker and take parameters from it
+func fetchState(p Params, method string, args interface{}) (Params, error) {
+
+	if p.Config == nil {
+		config, err := LoadConfig("")
+		if err != nil {
+			return p, &InputError{"", err}
+		}
+		p.Config = &config
+	}
+
+	client, err := rpc.DialHTTP("tcp", p.Config.rpcAddr())
+	if err != nil {
+		return p, &RemoteError{"dial", err}
+	}
+	defer client.Close()
+
+	var bp BrokerParams
+	err = client.Call(method, args, &bp)
+	if err != nil {
+		return p, &RemoteError{method, err}
+	}
+	p.Turns = bp.Turns
+	p.ImageWidth = bp.ImageWidth
+	p.ImageHeight = bp.ImageHeight
+	p.Rule, p.RuleSet = bp.Rule, true
+	p.Topology = bp.Topology
+	p.Direct = bp.Direct
+	p.Batch = bp.Batch
+	p.remote = &bp
+	return p, nil
 }
@@ -1,20 +1,24 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
-	"unsafe"
-
-	"uk.ac.bris.cs/gameoflife/util"
+	"time"
 )
 
+// Maximum number of operations running at the same time (others wait in the queue)
+const maxIoOperations = 4
+
 // ioState is the internal ioState of the io goroutine.
 type ioState struct {
-	params    Params
-	operation *ioOperation
-	cond      *sync.Cond
+	params Params
+	queue  []*ioOperation // Operations not yet started, in the order requested
+	cond   *sync.Cond     // Signalled when an operation is queued
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -31,127 +35,260 @@ const (
 	ioInput
 	// ioCheckIdle This constant is no longer needed because distributor is awakened after sync
 	ioQuit
+	ioCheckpointOutput
+	ioCheckpointInput
+	ioPatternInput
 )
 
 type ioOperation struct {
-	command   ioCommand
-	filename  string
-	data      []byte
-	completed bool
-}
-
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
-
-	file, ioError := os.Create("out/" + io.operation.filename + ".pgm")
-	util.Check(ioError)
-	defer file.Close()
-
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
-
-	_, ioError = file.Write(io.operation.data)
-	util.Check(ioError)
-
-	ioError = file.Sync()
-	util.Check(ioError)
-
-	fmt.Println("File", io.operation.filename, "output done!")
-}
-
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
-
-	data, ioError := os.ReadFile("images/" + io.operation.filename + ".pgm")
-	data_string := *(*string)(unsafe.Pointer(&data))
-	util.Check(ioError)
-
-	start_index := 0
-	var fields [4]string
-	fields_index := 0
-	for i := 0; i != len(data_string) && fields_index != 4; i++ {
-		switch data_string[i] {
-		case '\n':
-			fallthrough
-		case ' ':
-			fields[fields_index] = data_string[start_index:i]
-			fields_index++
-			start_index = i + 1
-		}
+	command  ioCommand
+	filename string
+	data     []byte
+	turn     int           // Number of completed turns stored in checkpoint
+	path     string        // Path of file written (set by io goroutine)
+	err      error         // Failure of the operation (set when completed)
+	done     chan struct{} // Closed when operation completed (made by sendIoRequest)
+}
+
+// File written by an operation (extension is chosen by command)
+type ioTarget struct {
+	command  ioCommand
+	filename string
+}
+
+// Name of output files of given turn (extension is added by io goroutine)
+// Placeholders of p.Output are replaced by size, turn and the time the run started
+func outputName(p Params, turn int, started time.Time) string {
+	if p.Output == "" {
+		return fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
 	}
+	return strings.NewReplacer(
+		"{w}", strconv.Itoa(p.ImageWidth),
+		"{h}", strconv.Itoa(p.ImageHeight),
+		"{turn}", strconv.Itoa(turn),
+		"{time}", started.Format("20060102-150405"),
+	).Replace(p.Output)
+}
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+// Path of output file of operation with given extension, kept in operation (its directory is created if missing)
+func (io *ioState) outputPath(operation *ioOperation, extension string) string {
+	if io.params.Output == "" {
+		_ = os.Mkdir("out", os.ModePerm)
+		operation.path = "out/" + operation.filename + extension
+	} else {
+		_ = os.MkdirAll(filepath.Dir(operation.filename), os.ModePerm)
+		operation.path = operation.filename + extension
 	}
+	return operation.path
+}
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+// writeImage receives an array of bytes and writes it to a pgm file (or a bit-packed pbm file when requested).
+func (io *ioState) writeImage(operation *ioOperation) error {
+	extension, format := ".pgm", "P5"
+	if io.params.OutputFormat == "pbm" {
+		extension, format = ".pbm", "P4"
+	}
+	path := io.outputPath(operation, extension)
+	image := Image{io.params.ImageWidth, io.params.ImageHeight, operation.data}
+	if ioError := WriteImage(path, image, format); ioError != nil {
+		return ioError
 	}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	fmt.Println("File", operation.filename, "output done!")
+	return nil
+}
+
+// readPgmImage opens a netpbm file and sends its data as an array of bytes.
+func (io *ioState) readPgmImage(operation *ioOperation) error {
+	path := operation.filename
+	image, ioError := ReadImage(path)
+	if ioError != nil {
+		return ioError
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	if image.Width != io.params.ImageWidth {
+		return &InputError{path, errors.New("incorrect width")}
 	}
 
-	io.operation.data = data[start_index:]
+	if image.Height != io.params.ImageHeight {
+		return &InputError{path, errors.New("incorrect height")}
+	}
+
+	operation.data = image.Pixels
 
-	fmt.Println("File", io.operation.filename, "input done!")
+	fmt.Println("File", operation.filename, "input done!")
+	return nil
+}
+
+// writePattern receives an array of bytes and writes its alive cells to an RLE or plaintext pattern file.
+func (io *ioState) writePattern(operation *ioOperation) error {
+	path := io.outputPath(operation, "."+io.params.OutputFormat)
+	pattern := patternFromPixels(io.params.ImageWidth, io.params.ImageHeight, io.params.Rule, operation.data)
+	if ioError := WritePattern(path, pattern); ioError != nil {
+		return ioError
+	}
+
+	fmt.Println("Pattern", operation.filename, "output done!")
+	return nil
+}
+
+// readPattern opens an RLE or plaintext pattern file and places its cells at the offset in an empty image.
+func (io *ioState) readPattern(operation *ioOperation) error {
+	pattern, ioError := ReadPattern(operation.filename)
+	if ioError != nil {
+		return ioError
+	}
+
+	offset := io.params.PatternOffset
+	if offset.X < 0 || offset.Y < 0 ||
+		offset.X+pattern.Width > io.params.ImageWidth || offset.Y+pattern.Height > io.params.ImageHeight {
+		return &InputError{operation.filename, fmt.Errorf("%dx%d pattern does not fit in image at (%d,%d)",
+			pattern.Width, pattern.Height, offset.X, offset.Y)}
+	}
+	operation.data = make([]byte, io.params.ImageWidth*io.params.ImageHeight)
+	for _, cell := range pattern.Cells {
+		operation.data[(offset.Y+cell.Y)*io.params.ImageWidth+offset.X+cell.X] = 255
+	}
+
+	fmt.Println("Pattern", operation.filename, "input done!")
+	return nil
+}
+
+// writeCheckpoint receives an array of bytes and writes it with the completed turn to a checkpoint file.
+func (io *ioState) writeCheckpoint(operation *ioOperation) error {
+	path := io.outputPath(operation, ".checkpoint")
+	checkpoint := Checkpoint{
+		Turn:   operation.turn,
+		Params: io.params,
+		Pixels: packCells(operation.data),
+	}
+	ioError := writeCheckpoint(path, checkpoint)
+	if ioError != nil {
+		return &IOError{path, ioError}
+	}
+
+	fmt.Println("Checkpoint", operation.filename, "output done!")
+	return nil
+}
+
+// readCheckpoint opens a checkpoint file and sends its data as an array of bytes with the completed turn.
+func (io *ioState) readCheckpoint(operation *ioOperation) error {
+
+	checkpoint, ioError := ReadCheckpoint(operation.filename)
+	if ioError != nil {
+		return ioError
+	}
+
+	if checkpoint.Params.ImageWidth != io.params.ImageWidth {
+		return &InputError{operation.filename, errors.New("incorrect width")}
+	}
+
+	if checkpoint.Params.ImageHeight != io.params.ImageHeight {
+		return &InputError{operation.filename, errors.New("incorrect height")}
+	}
+
+	operation.data = unpackCells(checkpoint.Pixels, io.params.ImageWidth*io.params.ImageHeight)
+	operation.turn = checkpoint.Turn
+
+	fmt.Println("Checkpoint", operation.filename, "input done!")
+	return nil
 }
 
 // startIo should be the entrypoint of the io goroutine.
+// Up to maxIoOperations operations run at the same time in goroutines of their own. Operations writing
+// the same file are completed one at a time in the order they were requested, so the last one is kept.
+// Quitting waits for all operations requested before.
 func (io *ioState) startIo() {
-
+	var running sync.WaitGroup
+	slots := make(chan struct{}, maxIoOperations)
+	writing := make(map[ioTarget]*ioOperation) // Last operation requested of each output file
 	for {
-		io.cond.Wait()
-		switch io.operation.command {
-		case ioInput:
-			io.readPgmImage()
-		case ioOutput:
-			io.writePgmImage()
-		case ioQuit:
-			io.cond.L.Unlock()
+		io.cond.L.Lock()
+		for len(io.queue) == 0 {
+			io.cond.Wait()
+		}
+		operation := io.queue[0]
+		io.queue = io.queue[1:]
+		io.cond.L.Unlock()
+
+		if operation.command == ioQuit {
+			running.Wait()
+			close(operation.done)
 			return
 		}
-		io.operation.completed = true
-		io.cond.Signal()
+
+		// Forget output files not being written any more
+		for key, last := range writing {
+			if io.completedIoRequest(last) {
+				delete(writing, key)
+			}
+		}
+		key := ioTarget{operation.command, operation.filename}
+		previous := writing[key]
+		if operation.command == ioOutput || operation.command == ioCheckpointOutput {
+			writing[key] = operation
+		}
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			if previous != nil {
+				<-previous.done
+			}
+			slots <- struct{}{}
+			io.run(operation)
+			<-slots
+			close(operation.done)
+		}()
+	}
+}
+
+// Complete an IO operation, keeping its failure in it
+func (io *ioState) run(operation *ioOperation) {
+	switch operation.command {
+	case ioInput:
+		operation.err = io.readPgmImage(operation)
+	case ioOutput:
+		if io.params.OutputFormat == "rle" || io.params.OutputFormat == "cells" {
+			operation.err = io.writePattern(operation)
+		} else {
+			operation.err = io.writeImage(operation)
+		}
+	case ioCheckpointInput:
+		operation.err = io.readCheckpoint(operation)
+	case ioPatternInput:
+		operation.err = io.readPattern(operation)
+	case ioCheckpointOutput:
+		operation.err = io.writeCheckpoint(operation)
 	}
 }
 
-// Initiate an IO request
+// Queue an IO request without waiting for it
 func (io *ioState) sendIoRequest(operation *ioOperation) {
+	operation.done = make(chan struct{})
 	io.cond.L.Lock()
-	io.operation = operation
+	io.queue = append(io.queue, operation)
 	io.cond.Signal()
 	io.cond.L.Unlock()
 }
 
-// Wait until last IO operation completed
-func (io *ioState) waitIoRequest() {
+// Wait until IO operation completed and return its failure
+func (io *ioState) waitIoRequest(operation *ioOperation) error {
+	<-operation.done
+	return operation.err
+}
 
-	io.cond.L.Lock()
-	for !io.operation.completed {
-		io.cond.Wait()
+// Check whether IO operation completed without waiting
+func (io *ioState) completedIoRequest(operation *ioOperation) bool {
+	select {
+	case <-operation.done:
+		return true
+	default:
+		return false
 	}
-	io.cond.L.Unlock()
 }
 
-// Send a signal to IO thread to quit
+// Send a signal to IO thread to quit after completing operations requested before
 func (io *ioState) quit() {
-	io.cond.L.Lock()
-	io.operation = &ioOperation{command: ioQuit}
-	io.cond.Signal()
-	io.cond.L.Unlock()
+	io.sendIoRequest(&ioOperation{command: ioQuit})
 }
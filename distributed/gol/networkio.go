@@ -20,29 +20,59 @@ const (
 	EVENT_QUIT
 	EVENT_KILL
 	EVENT_FLIPPED
+	EVENT_SYNC            // Flipped cells sent so far bring local controller to the state of current turn (direct mode)
+	EVENT_RECOVERING      // Worker nodes failed and evaluation is being moved to others
+	EVENT_RECOVERED       // Evaluation continues on worker nodes left
+	EVENT_RECOVERY_FAILED // No worker nodes left to continue (durable state is kept on broker)
 )
 
 // Connection object
 type Connection struct {
+	id          uint64 // ID issued by broker for claiming the connection in Init or Attach
 	conn        *net.TCPConn
 	result_chan chan []util.Cell
 	event_chan  chan byte
+	save_chan   chan uint64   // Requesters of saves streamed (zero when saved by the user)
+	done        chan struct{} // Closed when connection is closed by local controller
 }
 
 // Establish a new connection to broker
-func NewConnection(size_int int) *Connection {
-	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(54, 209, 41, 143), Port: 2001})
+func NewConnection(address string, size_int int) (*Connection, error) {
+	tcp_addr, err := net.ResolveTCPAddr("tcp", address)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+	conn, err := net.DialTCP("tcp", nil, tcp_addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read ID of connection issued by broker
+	var id_bytes [8]byte
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = io.ReadFull(conn, id_bytes[:])
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
 	conn_obj := &Connection{
+		id:          binary.LittleEndian.Uint64(id_bytes[:]),
 		conn:        conn,
 		result_chan: make(chan []util.Cell),
 		event_chan:  make(chan byte),
+		save_chan:   make(chan uint64),
+		done:        make(chan struct{}),
 	}
-	log.Print("Connection to 54.209.41.143:2001 established")
+	log.Printf("Connection to %s established", address)
 	go conn_obj.Monitor(size_int)
-	return conn_obj
+	return conn_obj, nil
+}
+
+// Close connection and stop monitoring goroutine
+func (conn *Connection) Close() {
+	close(conn.done)
+	conn.conn.Close()
 }
 
 // Repeatedly read data from connection until closed by broker
@@ -53,6 +83,7 @@ func (conn *Connection) Monitor(size_int int) {
 		log.Printf("Connection to %s closed", conn.conn.RemoteAddr().String())
 		close(conn.result_chan)
 		close(conn.event_chan)
+		close(conn.save_chan)
 	}()
 
 	conn.conn.SetReadDeadline(*new(time.Time))
@@ -61,10 +92,10 @@ func (conn *Connection) Monitor(size_int int) {
 	for {
 		message, err := buffer.ReadByte()
 		if err != nil {
-			if err == io.EOF {
-				return
+			if err != io.EOF {
+				log.Print(err)
 			}
-			panic("")
+			return
 		}
 		switch message {
 		case EVENT_FLIPPED:
@@ -72,19 +103,42 @@ func (conn *Connection) Monitor(size_int int) {
 			var length_bytes [8]byte
 			_, err := io.ReadFull(buffer, length_bytes[:])
 			if err != nil {
-				log.Panic(err)
+				log.Print(err)
+				return
 			}
 			data_length, _ := binary.Varint(length_bytes[:])
 			if data_length == 0 {
-				conn.result_chan <- []util.Cell{}
+				select {
+				case conn.result_chan <- []util.Cell{}:
+				case <-conn.done:
+					return
+				}
 			} else {
 				flipped_data := make([]byte, data_length)
 				_, err = io.ReadFull(buffer, flipped_data)
 				if err != nil {
-					log.Panic(err)
+					log.Print(err)
+					return
 				}
 				flipped := decompressFlipped(flipped_data, size_int)
-				conn.result_chan <- flipped
+				select {
+				case conn.result_chan <- flipped:
+				case <-conn.done:
+					return
+				}
+			}
+		case EVENT_SAVE:
+			// Reading ID of connection of requester
+			var requester_bytes [8]byte
+			_, err := io.ReadFull(buffer, requester_bytes[:])
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			select {
+			case conn.save_chan <- binary.LittleEndian.Uint64(requester_bytes[:]):
+			case <-conn.done:
+				return
 			}
 		case EVENT_TURN_COMPLETE:
 			fallthrough
@@ -92,14 +146,25 @@ func (conn *Connection) Monitor(size_int int) {
 			fallthrough
 		case EVENT_RESUME:
 			fallthrough
-		case EVENT_SAVE:
-			fallthrough
 		case EVENT_QUIT:
 			fallthrough
 		case EVENT_KILL:
-			conn.event_chan <- message
+			fallthrough
+		case EVENT_SYNC:
+			fallthrough
+		case EVENT_RECOVERING:
+			fallthrough
+		case EVENT_RECOVERED:
+			fallthrough
+		case EVENT_RECOVERY_FAILED:
+			select {
+			case conn.event_chan <- message:
+			case <-conn.done:
+				return
+			}
 		default:
-			panic("")
+			log.Printf("Unknown message %d from broker", message)
+			return
 		}
 	}
 }
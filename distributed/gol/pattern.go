@@ -0,0 +1,322 @@
+package gol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Maximum length of lines of RLE files written
+const rleLineLength = 70
+
+// Maximum number of cells (width times height) of patterns read
+const maxPatternCells = 1 << 28
+
+// Pattern is a set of alive cells read from or written to a Life pattern file.
+type Pattern struct {
+	Width   int
+	Height  int
+	Rule    Rule        // Rule given in the header (zero when absent)
+	RuleSet bool        // Header gives a rule (Rule is zero for B/S)
+	Cells   []util.Cell // Positions of alive cells relative to the top-left corner
+}
+
+// ReadPattern reads a pattern file in RLE (.rle) or plaintext (.cells) format.
+func ReadPattern(path string) (Pattern, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Pattern{}, &IOError{path, err}
+	}
+	var pattern Pattern
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".rle":
+		pattern, err = parseRLE(string(data))
+	case ".cells":
+		pattern, err = parseCells(string(data))
+	default:
+		err = errors.New("unknown pattern format (expected .rle or .cells)")
+	}
+	if err != nil {
+		return pattern, &InputError{path, err}
+	}
+	return pattern, nil
+}
+
+// WritePattern writes a pattern file in RLE or plaintext format chosen by the extension of path.
+func WritePattern(path string, pattern Pattern) error {
+	for _, cell := range pattern.Cells {
+		if cell.X < 0 || cell.Y < 0 || cell.X >= pattern.Width || cell.Y >= pattern.Height {
+			return &InputError{path, errors.New("cells outside of pattern size")}
+		}
+	}
+	var data string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".rle":
+		data = formatRLE(pattern)
+	case ".cells":
+		data = formatCells(pattern)
+	default:
+		return &InputError{path, errors.New("unknown pattern format (expected .rle or .cells)")}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return &IOError{path, err}
+	}
+	defer file.Close()
+	if _, err = file.WriteString(data); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return &IOError{path, err}
+	}
+	return nil
+}
+
+// Make pattern of alive pixels of an image
+func patternFromPixels(width, height int, rule Rule, pixels []uint8) Pattern {
+	pattern := Pattern{Width: width, Height: height, Rule: rule, RuleSet: true}
+	for i, pixel := range pixels {
+		if pixel != 0 {
+			pattern.Cells = append(pattern.Cells, util.Cell{X: i % width, Y: i / width})
+		}
+	}
+	return pattern
+}
+
+// Parse RLE pattern with "x = <width>, y = <height>[, rule = <rule>]" header
+func parseRLE(data string) (Pattern, error) {
+	var pattern Pattern
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	has_header := false
+	var body strings.Builder
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !has_header {
+			if err := parseRLEHeader(line, &pattern); err != nil {
+				return pattern, err
+			}
+			has_header = true
+			continue
+		}
+		body.WriteString(line)
+		if strings.IndexByte(line, '!') >= 0 {
+			break
+		}
+	}
+	if !has_header {
+		return pattern, errors.New("missing rle header")
+	}
+
+	// Decode runs of cells (b dead, o alive, $ end of row, ! end of pattern)
+	x, y, count := 0, 0, 0
+	for _, char := range body.String() {
+		switch {
+		case char >= '0' && char <= '9':
+			count = count*10 + int(char-'0')
+			if count > maxPatternCells {
+				return pattern, errors.New("run length too large")
+			}
+			continue
+		case char == ' ' || char == '\t':
+			continue
+		case char == '!':
+			return pattern, nil
+		}
+		if count == 0 {
+			count = 1
+		}
+		switch char {
+		case 'b', '.':
+			if x+count > pattern.Width {
+				return pattern, errors.New("cells outside of pattern size")
+			}
+			x += count
+		case '$':
+			x = 0
+			y += count
+		default:
+			if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z') {
+				return pattern, fmt.Errorf("unexpected %q in rle data", char)
+			}
+			if y >= pattern.Height || x+count > pattern.Width {
+				return pattern, errors.New("cells outside of pattern size")
+			}
+			for ; count != 0; count-- {
+				pattern.Cells = append(pattern.Cells, util.Cell{X: x, Y: y})
+				x++
+			}
+		}
+		count = 0
+	}
+	return pattern, nil
+}
+
+// Parse header line of RLE pattern
+func parseRLEHeader(line string, pattern *Pattern) error {
+	has_x, has_y := false, false
+	for _, item := range strings.Split(line, ",") {
+		key_value := strings.SplitN(item, "=", 2)
+		if len(key_value) != 2 {
+			return fmt.Errorf("invalid rle header %q", line)
+		}
+		key, value := strings.TrimSpace(key_value[0]), strings.TrimSpace(key_value[1])
+		var err error
+		switch key {
+		case "x":
+			pattern.Width, err = strconv.Atoi(value)
+			has_x = true
+		case "y":
+			pattern.Height, err = strconv.Atoi(value)
+			has_y = true
+		case "rule":
+			pattern.Rule, err = parseRLERule(value)
+			pattern.RuleSet = true
+		}
+		if err != nil {
+			return fmt.Errorf("invalid rle header %q: %w", line, err)
+		}
+	}
+	if !has_x || !has_y || pattern.Width < 0 || pattern.Height < 0 {
+		return fmt.Errorf("invalid rle header %q: expected x and y", line)
+	}
+	return checkPatternSize(pattern.Width, pattern.Height)
+}
+
+// Check that a pattern of given size is small enough to be allocated (width times height may overflow)
+func checkPatternSize(width, height int) error {
+	if width > 0 && height > maxPatternCells/width {
+		return fmt.Errorf("pattern size %dx%d too large", width, height)
+	}
+	return nil
+}
+
+// Parse rule of RLE header in B/S notation or in S/B notation such as "23/3"
+func parseRLERule(value string) (Rule, error) {
+	value = strings.SplitN(value, ":", 2)[0] // Bounded grid suffix is not supported
+	parts := strings.Split(value, "/")
+	if len(parts) == 2 && strings.Trim(parts[0]+parts[1], "012345678") == "" {
+		value = "B" + parts[1] + "/S" + parts[0]
+	}
+	return ParseRule(value)
+}
+
+// Parse plaintext pattern (! comment lines, . dead and O alive cells)
+func parseCells(data string) (Pattern, error) {
+	var pattern Pattern
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	rows := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		for x, char := range line {
+			switch char {
+			case '.':
+			case 'O', '*':
+				pattern.Cells = append(pattern.Cells, util.Cell{X: x, Y: rows})
+			default:
+				return pattern, fmt.Errorf("unexpected %q in plaintext row %d", char, rows)
+			}
+		}
+		rows++
+		if line != "" {
+			pattern.Height = rows
+		}
+		if len(line) > pattern.Width {
+			pattern.Width = len(line)
+		}
+		if err := checkPatternSize(pattern.Width, pattern.Height); err != nil {
+			return pattern, err
+		}
+	}
+	return pattern, nil
+}
+
+// Format pattern as RLE (runs of dead cells at the end of rows and trailing empty rows are omitted)
+func formatRLE(pattern Pattern) string {
+	rule := pattern.Rule
+	if !pattern.RuleSet && rule == (Rule{}) {
+		rule = Conway
+	}
+	rows := patternRows(pattern)
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "x = %d, y = %d, rule = %v\n", pattern.Width, pattern.Height, rule)
+
+	line_length := 0
+	emit := func(count int, tag byte) {
+		token := string(tag)
+		if count > 1 {
+			token = strconv.Itoa(count) + token
+		}
+		if line_length+len(token) > rleLineLength {
+			builder.WriteByte('\n')
+			line_length = 0
+		}
+		builder.WriteString(token)
+		line_length += len(token)
+	}
+	pending_rows := 0
+	for _, row := range rows {
+		for x := 0; x != len(row); {
+			start := x
+			alive := row[x]
+			for x != len(row) && row[x] == alive {
+				x++
+			}
+			if !alive && x == len(row) {
+				break
+			}
+			if pending_rows != 0 {
+				emit(pending_rows, '$')
+				pending_rows = 0
+			}
+			if alive {
+				emit(x-start, 'o')
+			} else {
+				emit(x-start, 'b')
+			}
+		}
+		pending_rows++
+	}
+	emit(1, '!')
+	builder.WriteByte('\n')
+	return builder.String()
+}
+
+// Format pattern as plaintext
+func formatCells(pattern Pattern) string {
+	var builder strings.Builder
+	for _, row := range patternRows(pattern) {
+		for _, alive := range row {
+			if alive {
+				builder.WriteByte('O')
+			} else {
+				builder.WriteByte('.')
+			}
+		}
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
+
+// Expand cells of pattern into rows
+func patternRows(pattern Pattern) [][]bool {
+	rows := make([][]bool, pattern.Height)
+	for y := range rows {
+		rows[y] = make([]bool, pattern.Width)
+	}
+	for _, cell := range pattern.Cells {
+		rows[cell.Y][cell.X] = true
+	}
+	return rows
+}
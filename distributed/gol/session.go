@@ -0,0 +1,118 @@
+package gol
+
+import (
+	"log"
+	"net/rpc"
+	"time"
+)
+
+// Back-off of reconnecting to broker
+const (
+	reconnectInitialDelay = time.Millisecond * 100
+	reconnectMaxDelay     = time.Second * 5
+	reconnectTimeout      = time.Second * 30
+)
+
+// Session running on broker that survives transient failures of network
+type remoteSession struct {
+	config   *Config
+	id       string // ID of session issued by broker
+	watching bool   // Session is run by another controller (never continued from durable state)
+	size_int int
+	client   *rpc.Client
+	conn     *Connection
+}
+
+// Connect to RPC service and streaming service of broker
+func dialSession(config *Config, size_int int) (*remoteSession, error) {
+	remote := &remoteSession{config: config, size_int: size_int}
+	if err := remote.dial(); err != nil {
+		return nil, err
+	}
+	return remote, nil
+}
+
+func (remote *remoteSession) dial() error {
+	client, err := rpc.DialHTTP("tcp", remote.config.rpcAddr())
+	if err != nil {
+		return err
+	}
+	log.Printf("RPC Server %s connected", remote.config.rpcAddr())
+	conn, err := NewConnection(remote.config.streamAddr(), remote.size_int)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	remote.client = client
+	remote.conn = conn
+	return nil
+}
+
+// Close connections to broker (session keeps running on broker)
+func (remote *remoteSession) close() {
+	if remote.client != nil {
+		remote.client.Close()
+		remote.client = nil
+	}
+	if remote.conn != nil {
+		remote.conn.Close()
+		remote.conn = nil
+	}
+}
+
+// Start a new session streaming to this connection
+func (remote *remoteSession) init(bp BrokerParams) error {
+	bp.Connection = remote.conn.id
+	return remote.client.Call("Broker.Init", bp, &remote.id)
+}
+
+// Attach this connection to the session and get current state of matrix
+func (remote *remoteSession) attach() (BrokerParams, error) {
+	var bp BrokerParams
+	err := remote.client.Call("Broker.Attach", SessionArgs{Session: remote.id, Connection: remote.conn.id}, &bp)
+	return bp, err
+}
+
+// Reconnect to broker with exponential back-off and attach to the session again
+// Return current state of matrix, or error if the session cannot be reached before timeout
+func (remote *remoteSession) reconnect() (BrokerParams, error) {
+	delay := reconnectInitialDelay
+	deadline := time.Now().Add(reconnectTimeout)
+	for {
+		remote.close()
+		bp, err := remote.rejoin()
+		if err == nil {
+			return bp, nil
+		}
+		log.Printf("Reconnecting to session %s failed: %s", remote.id, err.Error())
+		if time.Now().Add(delay).After(deadline) {
+			return bp, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// Connect and attach to the session
+// Session is continued from durable state if broker restarted since last connected
+func (remote *remoteSession) rejoin() (BrokerParams, error) {
+	if err := remote.dial(); err != nil {
+		return BrokerParams{}, err
+	}
+	bp, err := remote.attach()
+	if err == nil || remote.watching {
+		return bp, err
+	}
+	var restored BrokerParams
+	if remote.client.Call("Broker.Restore", SessionArgs{Session: remote.id}, &restored) != nil || restored.Session != remote.id {
+		return bp, err
+	}
+	if err = remote.init(restored); err != nil {
+		return bp, err
+	}
+	log.Printf("Session %s restored from durable state of broker", remote.id)
+	return restored, nil
+}
@@ -0,0 +1,588 @@
+package gol
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Simulator evaluates Game of Life in a session on the broker and keeps a local copy of the world.
+// Methods may be called from any goroutine. A session started by NewSimulator is held by the broker
+// between calls of Step, so it only evaluates the turns requested.
+type Simulator struct {
+	p            Params
+	remote       *remoteSession
+	client_mutex *sync.Mutex // Held while calling broker or reconnecting
+	mutex        *sync.Mutex // Protects local copy of state
+	cond         *sync.Cond  // Signalled when a turn completes or the session ends
+	matrix       [][]uint8
+	turn         int
+	count        int // Alive cells at count_turn
+	count_turn   int // Turn of last confirmed state (behind turn in direct mode)
+	paused       bool
+	ended        bool  // Session ended or connection lost
+	err          error // Failure ending the session
+
+	events  chan<- Event  // Events of the session (nil when not sent)
+	notices chan notice   // Turns to be written by distributor (nil when not sent)
+	closing chan struct{} // Closed by Close to stop following the session
+	done    chan struct{} // Closed when pump goroutine exits
+	once    *sync.Once
+}
+
+// Request of distributor writing the state of a turn
+type notice struct {
+	save   bool // Write image before checkpoint (checkpoint only when false)
+	auto   bool // Automatic snapshot (files of old ones are removed)
+	turn   int
+	pixels []uint8 // Copy of matrix at turn
+}
+
+// NewSimulator starts a session on the broker with a world of p.ImageHeight rows of p.ImageWidth cells (non-zero when alive).
+// The session ends at p.Turns. When p.Session is set, the running session of another controller is watched
+// instead and world is ignored (it keeps running until Step is called).
+func NewSimulator(p Params, world [][]uint8) (*Simulator, error) {
+	p.defaultRule()
+	if p.Config == nil {
+		config, err := LoadConfig("")
+		if err != nil {
+			return nil, &InputError{"", err}
+		}
+		p.Config = &config
+	}
+	if p.Session != "" {
+		attached, err := Attach(p)
+		if err != nil {
+			return nil, err
+		}
+		return startSimulator(attached, nil, 0, nil, nil)
+	}
+	if p.ImageWidth <= 0 || p.ImageHeight <= 0 || len(world) != p.ImageHeight {
+		return nil, &InputError{"", errors.New("world does not match image size")}
+	}
+	pixels := make([]uint8, 0, p.ImageWidth*p.ImageHeight)
+	for _, row := range world {
+		if len(row) != p.ImageWidth {
+			return nil, &InputError{"", errors.New("world does not match image size")}
+		}
+		for _, pixel := range row {
+			if pixel != 0 {
+				pixel = 255
+			}
+			pixels = append(pixels, pixel)
+		}
+	}
+	p.held = true
+	return startSimulator(p, pixels, 0, nil, nil)
+}
+
+// Connect to broker and start (or attach to) the session, taking ownership of pixel data at given turn
+// State is taken from broker when p.remote is set. Events and notices are sent if channels are not nil
+func startSimulator(p Params, pixels []uint8, turn int, events chan<- Event, notices chan notice) (*Simulator, error) {
+
+	// Connect to RPC service and data streaming service of broker
+	remote, err := dialSession(p.Config, getSizeOfInt(p.ImageWidth, p.ImageHeight))
+	if err != nil {
+		return nil, &RemoteError{"dial", err}
+	}
+
+	// Attach to running session, or take state fetched from broker
+	if p.Session != "" {
+		remote.id = p.Session
+		remote.watching = true
+		bp, err := remote.attach()
+		if err != nil {
+			remote.close()
+			return nil, &RemoteError{"Broker.Attach", err}
+		}
+		log.Printf("Broker.Attach: session %s (at %d)", remote.id, bp.Turn)
+		pixels = unpackCells(bp.Pixels, p.ImageWidth*p.ImageHeight)
+		turn = bp.Turn
+	} else if p.remote != nil {
+		pixels = unpackCells(p.remote.Pixels, p.ImageWidth*p.ImageHeight)
+		turn = p.remote.Turn
+	}
+
+	sim := &Simulator{
+		p:            p,
+		remote:       remote,
+		client_mutex: new(sync.Mutex),
+		mutex:        new(sync.Mutex),
+		matrix:       make([][]uint8, p.ImageHeight),
+		turn:         turn,
+		count_turn:   turn,
+		events:       events,
+		notices:      notices,
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+		once:         new(sync.Once),
+	}
+	sim.cond = sync.NewCond(sim.mutex)
+
+	// Keep a local copy of pixel matrix
+	flipping_buffer := make([]util.Cell, 0, 1024)
+	for y := 0; y != p.ImageHeight; y++ {
+		sim.matrix[y] = pixels[y*p.ImageWidth : (y+1)*p.ImageWidth]
+		for x := 0; x != p.ImageWidth; x++ {
+			if sim.matrix[y][x] != 0 {
+				sim.count++
+				flipping_buffer = append(flipping_buffer, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+
+	// Prepare task (unless watching session of another controller)
+	if p.Session == "" {
+		bp := BrokerParams{
+			Turns:       p.Turns,
+			Turn:        turn,
+			Threads:     p.Threads,
+			ImageWidth:  p.ImageWidth,
+			ImageHeight: p.ImageHeight,
+			Rule:        p.Rule,
+			Topology:    p.Topology,
+			Direct:      p.Direct,
+			Batch:       p.Batch,
+			Held:        p.held,
+			SyncEvery:   p.SnapshotInterval,
+		}
+		if p.remote != nil {
+			bp.Session = p.remote.Session
+			bp.Pixels = p.remote.Pixels
+			bp.SizeInt = p.remote.SizeInt
+		} else {
+			compressMatrix(&bp, pixels, flipping_buffer)
+		}
+		if err := remote.init(bp); err != nil {
+			remote.close()
+			return nil, &RemoteError{"Broker.Init", err}
+		}
+		log.Printf("Broker.Init: %dx%dx%d-%d %v (from %d) session %s",
+			p.ImageWidth, p.ImageHeight, p.Turns, p.Threads, p.Rule, turn, remote.id)
+	}
+
+	sim.send(CellsFlipped{turn, flipping_buffer})
+	sim.send(StateChange{turn, Executing})
+	go sim.pump()
+	return sim, nil
+}
+
+// Apply flipped cells and events streamed by broker until the session ends
+// In direct mode, alive count is updated only when broker collects state from worker nodes
+func (sim *Simulator) pump() {
+	defer close(sim.done)
+	if sim.notices != nil {
+		defer close(sim.notices)
+	}
+	defer func() {
+		sim.mutex.Lock()
+		sim.ended = true
+		sim.cond.Broadcast()
+		sim.mutex.Unlock()
+	}()
+
+	unconfirmed_count := sim.count
+	checkpoint_due := false
+	snapshot_due := 0 // Turn of automatic snapshot waiting for state collected in direct mode (zero when none)
+	recovering := false
+	for sim.turn < sim.p.Turns || sim.count_turn != sim.turn {
+		select {
+		case <-sim.closing:
+			return
+		case flipped, ok := <-sim.remote.conn.result_chan:
+			if !ok {
+				if sim.reconnect(&recovering) {
+					unconfirmed_count = sim.count
+					continue
+				}
+				return
+			}
+			sim.mutex.Lock()
+			for _, cell := range flipped {
+				if sim.matrix[cell.Y][cell.X] == 0 {
+					sim.matrix[cell.Y][cell.X] = 255
+					unconfirmed_count++
+				} else {
+					sim.matrix[cell.Y][cell.X] = 0
+					unconfirmed_count--
+				}
+			}
+			turn := sim.turn
+			sim.mutex.Unlock()
+			sim.send(CellsFlipped{turn, flipped})
+		case requester, ok := <-sim.remote.conn.save_chan:
+			if !ok {
+				if sim.reconnect(&recovering) {
+					unconfirmed_count = sim.count
+					continue
+				}
+				return
+			}
+			// Automatic snapshots of other controllers are left to them
+			if requester == 0 {
+				sim.notify(true, false)
+			} else if requester == sim.remote.conn.id {
+				sim.notify(true, true)
+			}
+		case event, ok := <-sim.remote.conn.event_chan:
+			if !ok {
+				if sim.reconnect(&recovering) {
+					unconfirmed_count = sim.count
+					continue
+				}
+				return
+			}
+			turn := sim.turn // Only changed by this goroutine
+			switch event {
+			case EVENT_TURN_COMPLETE:
+				sim.send(TurnComplete{turn})
+				turn++
+				log.Printf("Turn result [%d] collected", turn)
+				sim.mutex.Lock()
+				sim.turn = turn
+				if !sim.p.Direct {
+					sim.count = unconfirmed_count
+					sim.count_turn = turn
+				}
+				sim.cond.Broadcast()
+				sim.mutex.Unlock()
+				if sim.p.CheckpointInterval > 0 && turn%sim.p.CheckpointInterval == 0 {
+					checkpoint_due = true
+				}
+				if checkpoint_due && sim.count_turn == turn {
+					sim.notify(false, false)
+					checkpoint_due = false
+				}
+				if sim.p.SnapshotInterval > 0 && turn%sim.p.SnapshotInterval == 0 {
+					snapshot_due = turn
+				}
+				if snapshot_due == turn && sim.count_turn == turn {
+					sim.notify(true, true)
+					snapshot_due = 0
+				}
+			case EVENT_SYNC:
+				sim.mutex.Lock()
+				sim.count = unconfirmed_count
+				sim.count_turn = turn
+				sim.cond.Broadcast()
+				sim.mutex.Unlock()
+				if checkpoint_due {
+					sim.notify(false, false)
+					checkpoint_due = false
+				}
+				// Turn due is collected by broker for the controller that started the session
+				if snapshot_due == turn {
+					sim.notify(true, true)
+				}
+				snapshot_due = 0
+			case EVENT_RECOVERING:
+				recovering = true
+				sim.send(RecoveryChange{turn, Recovering})
+			case EVENT_RECOVERED:
+				recovering = false
+				sim.send(RecoveryChange{turn, Recovered})
+			case EVENT_RECOVERY_FAILED:
+				log.Printf("Session %s stopped: no worker nodes left", sim.remote.id)
+				sim.send(RecoveryChange{turn, RecoveryFailed})
+				sim.fail(&RemoteError{"recovery", errors.New("no worker nodes left")})
+				return
+			case EVENT_RESUME:
+				sim.setPaused(false)
+				sim.send(StateChange{turn, Executing})
+				log.Print("Continuing")
+			case EVENT_PAUSE:
+				sim.setPaused(true)
+				sim.send(StateChange{turn, Paused})
+			case EVENT_KILL:
+				log.Printf("Session %s killed", sim.remote.id)
+				return
+			case EVENT_QUIT:
+				return
+			}
+		}
+	}
+}
+
+// Reconnect to the session (replace local matrix with the state replayed by broker)
+// Return false if the session is lost or the simulator is closing
+func (sim *Simulator) reconnect(recovering *bool) bool {
+	select {
+	case <-sim.closing:
+		return false
+	default:
+	}
+	sim.client_mutex.Lock()
+	bp, err := sim.remote.reconnect()
+	sim.client_mutex.Unlock()
+	if err != nil {
+		log.Printf("Session %s lost at turn %d: %s", sim.remote.id, sim.turn, err.Error())
+		sim.fail(&RemoteError{"reconnect", err})
+		return false
+	}
+	pixels := unpackCells(bp.Pixels, sim.p.ImageWidth*sim.p.ImageHeight)
+	flipped := make([]util.Cell, 0, 1024)
+	sim.mutex.Lock()
+	sim.count = 0
+	for y := 0; y != sim.p.ImageHeight; y++ {
+		for x := 0; x != sim.p.ImageWidth; x++ {
+			pixel := pixels[y*sim.p.ImageWidth+x]
+			if sim.matrix[y][x] != pixel {
+				sim.matrix[y][x] = pixel
+				flipped = append(flipped, util.Cell{X: x, Y: y})
+			}
+			if pixel != 0 {
+				sim.count++
+			}
+		}
+	}
+	sim.turn = bp.Turn
+	sim.count_turn = bp.Turn
+	sim.cond.Broadcast()
+	sim.mutex.Unlock()
+	sim.send(CellsFlipped{bp.Turn, flipped})
+	log.Printf("Session %s attached again at turn %d", sim.remote.id, bp.Turn)
+	if *recovering {
+		// Detached by broker rolling back to the turn recovery continues from
+		*recovering = false
+		sim.send(RecoveryChange{bp.Turn, Recovered})
+	}
+	return true
+}
+
+// Send event if events channel is set
+func (sim *Simulator) send(event Event) {
+	if sim.events != nil {
+		sim.events <- event
+	}
+}
+
+// Ask distributor to write current state (dropped when closing)
+func (sim *Simulator) notify(save, auto bool) {
+	if sim.notices == nil {
+		return
+	}
+	sim.mutex.Lock()
+	n := notice{save: save, auto: auto, turn: sim.turn, pixels: sim.copyPixels()}
+	sim.mutex.Unlock()
+	sim.deliver(n)
+}
+
+// Send notice to distributor (dropped when closing or when notices are not sent)
+func (sim *Simulator) deliver(n notice) {
+	if sim.notices == nil {
+		return
+	}
+	select {
+	case sim.notices <- n:
+	case <-sim.closing:
+	}
+}
+
+// Record the failure ending the session
+func (sim *Simulator) fail(err error) {
+	sim.mutex.Lock()
+	if sim.err == nil {
+		sim.err = err
+	}
+	sim.mutex.Unlock()
+}
+
+func (sim *Simulator) setPaused(paused bool) {
+	sim.mutex.Lock()
+	sim.paused = paused
+	sim.mutex.Unlock()
+}
+
+// Send request to the session, making the stream reconnect if broker cannot be reached
+func (sim *Simulator) call(method string, args interface{}, reply interface{}) error {
+	sim.client_mutex.Lock()
+	defer sim.client_mutex.Unlock()
+	if sim.remote.client == nil {
+		return errors.New("not connected to broker")
+	}
+	err := sim.remote.client.Call(method, args, reply)
+	if err != nil {
+		log.Print(err.Error())
+		if _, refused := err.(rpc.ServerError); !refused && sim.remote.conn != nil {
+			sim.remote.conn.conn.Close()
+		}
+	}
+	return err
+}
+
+func (sim *Simulator) sessionArgs() SessionArgs {
+	return SessionArgs{Session: sim.remote.id}
+}
+
+// Step evaluates n turns and returns the number of turns evaluated. The session is held after them.
+// Step waits while the session is paused, and returns early if the session ends or the simulator is closed.
+func (sim *Simulator) Step(n int) int {
+	var target int // Turn session is held at after n turns
+	if n <= 0 || sim.call("Broker.Step", StepArgs{Session: sim.remote.id, Turns: n}, &target) != nil {
+		return 0
+	}
+
+	// Wait for state of target turn to be streamed back
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	for (sim.turn < target || sim.count_turn != sim.turn) && !sim.ended {
+		sim.cond.Wait()
+	}
+	evaluated := n - (target - sim.turn)
+	if evaluated < 0 {
+		evaluated = 0
+	} else if evaluated > n {
+		evaluated = n
+	}
+	return evaluated
+}
+
+// Pause makes Step wait at the end of current turn until Resume is called (a running session is paused).
+func (sim *Simulator) Pause() error {
+	return sim.call("Broker.Pause", sim.sessionArgs(), &struct{}{})
+}
+
+// Resume continues a paused Step (or a paused session running until its last turn).
+func (sim *Simulator) Resume() error {
+	return sim.call("Broker.Resume", sim.sessionArgs(), &struct{}{})
+}
+
+// Save makes broker send the state of current turn to every controller attached to the session.
+func (sim *Simulator) Save() error {
+	return sim.call("Broker.Save", SaveArgs{Session: sim.remote.id}, &struct{}{})
+}
+
+// Make broker send the state of current turn as an automatic snapshot of this controller
+// Other controllers attached to the session receive it too, but leave it to this one
+func (sim *Simulator) autosave() error {
+	sim.client_mutex.Lock()
+	conn := sim.remote.conn
+	sim.client_mutex.Unlock()
+	if conn == nil {
+		return errors.New("not connected to broker")
+	}
+	return sim.call("Broker.Save", SaveArgs{Session: sim.remote.id, Requester: conn.id}, &struct{}{})
+}
+
+// Quit stops the session at the end of current turn.
+func (sim *Simulator) Quit() error {
+	return sim.call("Broker.Quit", sim.sessionArgs(), &struct{}{})
+}
+
+// Kill stops the session without writing output (other sessions on the broker keep running).
+func (sim *Simulator) Kill() error {
+	return sim.call("Broker.Kill", sim.sessionArgs(), &struct{}{})
+}
+
+// Paused reports whether the session is paused.
+func (sim *Simulator) Paused() bool {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.paused
+}
+
+// Turn returns the number of completed turns.
+func (sim *Simulator) Turn() int {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.turn
+}
+
+// AliveCount returns the number of alive cells at the last turn whose state was collected by the broker.
+func (sim *Simulator) AliveCount() int {
+	_, count := sim.counted()
+	return count
+}
+
+// Turn and number of alive cells of last confirmed state
+func (sim *Simulator) counted() (int, int) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.count_turn, sim.count
+}
+
+// AliveCells returns the positions of alive cells row by row.
+func (sim *Simulator) AliveCells() []util.Cell {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	cells := make([]util.Cell, 0, sim.count)
+	for i := 0; i != sim.p.ImageHeight; i++ {
+		for j := 0; j != sim.p.ImageWidth; j++ {
+			if sim.matrix[i][j] != 0 {
+				cells = append(cells, util.Cell{X: j, Y: i})
+			}
+		}
+	}
+	return cells
+}
+
+// Snapshot returns a copy of the world (255 for alive cells, 0 for dead cells).
+func (sim *Simulator) Snapshot() [][]uint8 {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	world := make([][]uint8, sim.p.ImageHeight)
+	for y := range world {
+		world[y] = append([]uint8(nil), sim.matrix[y]...)
+	}
+	return world
+}
+
+// Copy of pixels row by row (mutex must be held unless pump goroutine exited)
+func (sim *Simulator) copyPixels() []uint8 {
+	pixels := make([]uint8, 0, sim.p.ImageWidth*sim.p.ImageHeight)
+	for _, row := range sim.matrix {
+		pixels = append(pixels, row...)
+	}
+	return pixels
+}
+
+// SetCell sets the state of the cell at (x, y) at the end of current turn.
+func (sim *Simulator) SetCell(x, y int, alive bool) error {
+	return sim.setRegion(util.Cell{X: x, Y: y}, util.Cell{X: x + 1, Y: y + 1}, alive)
+}
+
+// ClearRegion kills all cells from start to end (not inclusive) at the end of current turn.
+func (sim *Simulator) ClearRegion(start, end util.Cell) error {
+	return sim.setRegion(start, end, false)
+}
+
+// Set all cells of a region to the same state (flipped cells are streamed back by broker)
+func (sim *Simulator) setRegion(start, end util.Cell, alive bool) error {
+	if start.X < 0 || start.Y < 0 || end.X > sim.p.ImageWidth || end.Y > sim.p.ImageHeight ||
+		start.X > end.X || start.Y > end.Y {
+		return &InputError{"", errors.New("region outside of world")}
+	}
+	err := sim.call("Broker.Edit", EditArgs{Session: sim.remote.id, Start: start, End: end, Alive: alive}, &struct{}{})
+	if err != nil {
+		return &RemoteError{"Broker.Edit", err}
+	}
+	return nil
+}
+
+// Err returns the failure ending the session, or nil.
+func (sim *Simulator) Err() error {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.err
+}
+
+// Close disconnects from the broker. A session started by NewSimulator is quit first, since it
+// would otherwise be held on the broker with its worker nodes. A watched session keeps running.
+func (sim *Simulator) Close() {
+	sim.once.Do(func() {
+		sim.mutex.Lock()
+		ended := sim.ended
+		sim.mutex.Unlock()
+		if sim.p.held && !ended {
+			sim.Quit()
+		}
+		close(sim.closing)
+		<-sim.done
+		sim.client_mutex.Lock()
+		sim.remote.close()
+		sim.client_mutex.Unlock()
+	})
+}
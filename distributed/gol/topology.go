@@ -0,0 +1,52 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Topology describes how the edges of the grid are connected.
+type Topology uint8
+
+const (
+	Torus       Topology = iota // Both pairs of edges wrap around (default)
+	DeadBorder                  // Cells outside the grid are permanently dead
+	Reflective                  // Edges are mirrored, so cells just outside the grid copy the edge cells
+	KleinBottle                 // Left and right edges wrap around, top and bottom edges wrap around flipped
+	Cylinder                    // Left and right edges wrap around, top and bottom edges are dead borders
+)
+
+// Parse topology name (torus, dead, reflective, klein or cylinder)
+func ParseTopology(name string) (Topology, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "torus":
+		return Torus, nil
+	case "dead", "deadborder":
+		return DeadBorder, nil
+	case "reflective", "mirror":
+		return Reflective, nil
+	case "klein", "kleinbottle":
+		return KleinBottle, nil
+	case "cylinder":
+		return Cylinder, nil
+	default:
+		return Torus, fmt.Errorf("unknown topology %q: expected torus, dead, reflective, klein or cylinder", name)
+	}
+}
+
+func (topology Topology) String() string {
+	switch topology {
+	case Torus:
+		return "torus"
+	case DeadBorder:
+		return "dead"
+	case Reflective:
+		return "reflective"
+	case KleinBottle:
+		return "klein"
+	case Cylinder:
+		return "cylinder"
+	default:
+		return "Incorrect Topology"
+	}
+}
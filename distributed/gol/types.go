@@ -12,11 +12,46 @@ type Block struct {
 type Partition []Block // A set of blocks
 
 type BrokerParams struct {
+	Session     string // ID of session (issued by Init when empty)
+	Connection  uint64 // ID of streaming connection of local controller issued on connect
 	Turns       int
+	Turn        int // Number of completed turns to start from (non-zero when resuming from a checkpoint)
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
-	Pixels      []byte // Compressed pixel data
-	Initials    []byte // Compressed positions of initial alive cells (used when Pixels is nil)
-	SizeInt     int    // Minimum number of bytes to represent the whole range of width and height
+	Rule        Rule     // Life-like rule
+	Topology    Topology // Boundary condition at the edges of the grid
+	Pixels      []byte   // Compressed pixel data
+	Initials    []byte   // Compressed positions of initial alive cells (used when Pixels is nil)
+	SizeInt     int      // Minimum number of bytes to represent the whole range of width and height
+	Direct      bool     // Worker nodes exchange boundary flips directly (state collected by broker only when needed)
+	Batch       int      // Number of turns per RPC to worker nodes (chosen by broker from round-trip time when zero)
+	Held        bool     // Session only evaluates turns requested by Step
+	SyncEvery   int      // State is also collected at multiples of this number of turns in direct mode (automatic snapshots)
+}
+
+// Arguments of requests to a running session
+type SessionArgs struct {
+	Session    string // ID of session issued by Init
+	Connection uint64 // ID of streaming connection to attach (zero to get state only)
+}
+
+// Arguments of saving the state of a running session
+type SaveArgs struct {
+	Session   string // ID of session issued by Init
+	Requester uint64 // ID of streaming connection of local controller taking an automatic snapshot (zero when saved by the user)
+}
+
+// Arguments of evaluating turns of a running session and holding it after them
+type StepArgs struct {
+	Session string // ID of session issued by Init
+	Turns   int    // Number of turns to evaluate
+}
+
+// Arguments of setting all cells of a region of a running session to the same state
+type EditArgs struct {
+	Session string    // ID of session issued by Init
+	Start   util.Cell // Top-left corner of region
+	End     util.Cell // Bottom-right corner of region (not inclusive)
+	Alive   bool      // State cells of region are set to
 }
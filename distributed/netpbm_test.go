@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestNetpbm tests reading and writing P1, P2, P4 and P5 images with comments, arbitrary maxval and malformed files,
+// and bit-packed output of a 16x16 image run.
+func TestNetpbm(t *testing.T) {
+	dir := t.TempDir()
+	image, err := gol.ReadImage("images/16x16.pgm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"P1", "P2", "P4", "P5"} {
+		t.Run("write "+format, func(t *testing.T) {
+			path := filepath.Join(dir, format+".pnm")
+			if err := gol.WriteImage(path, image, format); err != nil {
+				t.Fatal(err)
+			}
+			written, err := gol.ReadImage(path)
+			if err != nil || written.Width != 16 || written.Height != 16 || !bytes.Equal(written.Pixels, image.Pixels) {
+				t.Errorf("Expected %v image to read back the same pixels (%v)", format, err)
+			}
+		})
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		expected []uint8
+	}{
+		{"plain bitmap", "P1\n# packed digits\n4 1\n0110", []uint8{0, 255, 255, 0}},
+		{"plain greymap", "P2\n# comment\n3 2\n15\n0 7 8\n15 # comment\n 3 9\n", []uint8{0, 0, 255, 255, 0, 255}},
+		{"binary whitespace", "P5 2 2 255\n\xff\n \xff", []uint8{255, 0, 0, 255}},
+		{"16-bit greymap", "P5\n2 1\n65535\n\x80\x00\x7f\xff", []uint8{255, 0}},
+		{"bitmap", "P4\n10 1\n\x80\x40", []uint8{255, 0, 0, 0, 0, 0, 0, 0, 0, 255}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, "test.pnm")
+			os.WriteFile(path, []byte(test.data), 0644)
+			image, err := gol.ReadImage(path)
+			if err != nil || !bytes.Equal(image.Pixels, test.expected) {
+				t.Errorf("Expected %v, got %v (%v)", test.expected, image.Pixels, err)
+			}
+		})
+	}
+
+	for _, data := range []string{"P5\n2 2\n255\n\xff", "P6\n1 1\n255\n\xff\xff\xff", "P2\n2 1\n15\n3 16\n", "P5\n-2 2\n255\n"} {
+		t.Run("malformed", func(t *testing.T) {
+			path := filepath.Join(dir, "malformed.pnm")
+			os.WriteFile(path, []byte(data), 0644)
+			var inputError *gol.InputError
+			if _, err := gol.ReadImage(path); !errors.As(err, &inputError) {
+				t.Errorf("Expected an InputError for %q, got %v", data, err)
+			}
+		})
+	}
+
+	t.Run("run", func(t *testing.T) {
+		path := filepath.Join(dir, "input.pgm")
+		gol.WriteImage(path, image, "P2")
+		p := gol.Params{Turns: 1, Threads: 4, Input: path, Output: filepath.Join(dir, "output"), OutputFormat: "pbm"}
+		events := make(chan gol.Event)
+		go gol.Run(p, events, nil)
+		var cells []util.Cell
+		for event := range events {
+			switch e := event.(type) {
+			case gol.FinalTurnComplete:
+				cells = e.Alive
+			}
+		}
+		p.ImageWidth, p.ImageHeight = 16, 16
+		expectedAlive := readAliveCells("check/images/16x16x1.pgm", 16, 16)
+		assertEqualBoard(t, cells, expectedAlive, p)
+
+		// Bit-packed output reads back as the same cells
+		data, err := os.ReadFile(filepath.Join(dir, "output.pbm"))
+		if err != nil || !bytes.HasPrefix(data, []byte("P4\n16 16\n")) || len(data) != len("P4\n16 16\n")+16*2 {
+			t.Fatalf("Expected bit-packed 16x16 pbm output (%v)", err)
+		}
+		output, err := gol.ReadImage(filepath.Join(dir, "output.pbm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var outputAlive []util.Cell
+		for i, pixel := range output.Pixels {
+			if pixel != 0 {
+				outputAlive = append(outputAlive, util.Cell{X: i % 16, Y: i / 16})
+			}
+		}
+		assertEqualBoard(t, outputAlive, expectedAlive, p)
+	})
+}
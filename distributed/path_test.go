@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPaths tests reading an input image of unspecified size from another directory and writing to an output template.
+func TestPaths(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("images/16x16.pgm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "shared", "board.pgm")
+	os.MkdirAll(filepath.Dir(input), os.ModePerm)
+	if err := os.WriteFile(input, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "results", "{w}x{h}-{turn}-{time}")
+	p := gol.Params{Turns: 1, Threads: 2, Input: input, Output: output}
+
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var cells []util.Cell
+	filename := ""
+	for event := range events {
+		switch e := event.(type) {
+		case gol.FinalTurnComplete:
+			cells = e.Alive
+		case gol.ImageOutputComplete:
+			filename = e.Filename
+		}
+	}
+	p.ImageWidth, p.ImageHeight = 16, 16
+	expectedAlive := readAliveCells("check/images/16x16x1.pgm", 16, 16)
+	assertEqualBoard(t, cells, expectedAlive, p)
+
+	if !strings.HasPrefix(filename, filepath.Join(dir, "results", "16x16-1-")) || strings.Contains(filename, "{") {
+		t.Fatalf("Expected output named from template, got %q", filename)
+	}
+	assertEqualBoard(t, readAliveCells(filename+".pgm", 16, 16), expectedAlive, p)
+}
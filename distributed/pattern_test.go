@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestPattern tests reading and writing RLE and plaintext patterns, and running a Gosper glider gun placed in a 64x64 image.
+func TestPattern(t *testing.T) {
+	gun, err := gol.ReadPattern("patterns/gosperglidergun.rle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("read", func(t *testing.T) {
+		if gun.Width != 36 || gun.Height != 9 || gun.Rule != gol.Conway || len(gun.Cells) != 36 {
+			t.Errorf("Expected 36x9 B3/S23 pattern of 36 cells, got %vx%v %v of %v cells",
+				gun.Width, gun.Height, gun.Rule, len(gun.Cells))
+		}
+		glider, err := gol.ReadPattern("patterns/glider.cells")
+		expected := []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+		if err != nil || glider.Width != 3 || glider.Height != 3 || !checkEqualBoard(glider.Cells, expected) {
+			t.Errorf("Expected 3x3 glider, got %v (%v)", glider, err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		invalid := map[string]string{
+			"huge.rle":     "x = 1000000000, y = 1000000000\n!\n",
+			"overflow.rle": "x = 4611686018427387904, y = 4\n!\n",
+			"dead.rle":     "x = 3, y = 1\n2o2b!\n",
+			"run.rle":      "x = 3, y = 1\n99999999999999999999o!\n",
+			"huge.cells":   strings.Repeat("O\n", 1<<15) + strings.Repeat(".", 1<<14) + "O\n",
+		}
+		for name, data := range invalid {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var inputError *gol.InputError
+			if _, err := gol.ReadPattern(path); !errors.As(err, &inputError) {
+				t.Errorf("Expected an InputError for %v, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		for _, name := range []string{"gun.rle", "gun.cells"} {
+			path := filepath.Join(t.TempDir(), name)
+			if err := gol.WritePattern(path, gun); err != nil {
+				t.Fatal(err)
+			}
+			written, err := gol.ReadPattern(path)
+			if err != nil || written.Width != gun.Width || written.Height != gun.Height ||
+				!checkEqualBoard(written.Cells, gun.Cells) {
+				t.Errorf("Expected %v to read back the same pattern, got %v (%v)", name, written, err)
+			}
+		}
+
+		// B/S is written as given rather than replaced by Conway's Game of Life
+		path := filepath.Join(t.TempDir(), "empty.rle")
+		if err := gol.WritePattern(path, gol.Pattern{Width: 3, Height: 3, RuleSet: true}); err != nil {
+			t.Fatal(err)
+		}
+		written, err := gol.ReadPattern(path)
+		if err != nil || !written.RuleSet || written.Rule != (gol.Rule{}) {
+			t.Errorf("Expected B/S pattern to read back with its rule, got %v (%v)", written, err)
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 60, Threads: 4,
+			Pattern: "patterns/gosperglidergun.rle", PatternOffset: util.Cell{X: 10, Y: 20}, OutputFormat: "rle"}
+		initialAlive := make([]util.Cell, len(gun.Cells))
+		for i, cell := range gun.Cells {
+			initialAlive[i] = util.Cell{X: cell.X + p.PatternOffset.X, Y: cell.Y + p.PatternOffset.Y}
+		}
+		expectedAlive := referenceTurns(initialAlive, p)
+
+		emptyOutFolder()
+		events := make(chan gol.Event)
+		go gol.Run(p, events, nil)
+		var cells []util.Cell
+		for event := range events {
+			switch e := event.(type) {
+			case gol.FinalTurnComplete:
+				cells = e.Alive
+			}
+		}
+		assertEqualBoard(t, cells, expectedAlive, p)
+
+		output, err := gol.ReadPattern("out/64x64x60.rle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqualBoard(t, output.Cells, expectedAlive, p)
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/record"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRecord tests recording a 16x16 image run on 8 turns as an animated GIF and as a PNG sequence,
+// with a stride, scale and region of interest.
+func TestRecord(t *testing.T) {
+	p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 8, Threads: 4}
+	initialAlive := readAliveCells("images/16x16.pgm", p.ImageWidth, p.ImageHeight)
+	region := image.Rect(4, 2, 12, 14)
+	tests := []struct {
+		name   string
+		stride int
+	}{
+		{"animation.gif", 2},
+		{"frame.png", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := record.Options{Path: filepath.Join(t.TempDir(), "record", test.name), Stride: test.stride, Scale: 3, Region: region}
+			recorder, err := record.NewRecorder(p, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := make(chan gol.Event)
+			go gol.Run(p, events, nil)
+			for range recorder.Tee(events) {
+			}
+			if err := recorder.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Frames at turn 0, every stride turns and the final turn
+			var turns []int
+			for turn := 0; turn < p.Turns; turn += test.stride {
+				turns = append(turns, turn)
+			}
+			turns = append(turns, p.Turns)
+			frames := readFrames(t, options.Path, len(turns))
+			if len(frames) != len(turns) || recorder.Frames() != len(turns) {
+				t.Fatalf("Expected %v frames, got %v", len(turns), len(frames))
+			}
+			for i, turn := range turns {
+				p := p
+				p.Turns = turn
+				expected := referenceTurns(initialAlive, p)
+				var alive []util.Cell
+				for y := region.Min.Y; y != region.Max.Y; y++ {
+					for x := region.Min.X; x != region.Max.X; x++ {
+						r, _, _, _ := frames[i].At((x-region.Min.X)*3+1, (y-region.Min.Y)*3+1).RGBA()
+						if r != 0 {
+							alive = append(alive, util.Cell{X: x, Y: y})
+						}
+					}
+				}
+				var expectedInRegion []util.Cell
+				for _, cell := range expected {
+					if (image.Point{cell.X, cell.Y}).In(region) {
+						expectedInRegion = append(expectedInRegion, cell)
+					}
+				}
+				if frames[i].Bounds().Dx() != region.Dx()*3 || !checkEqualBoard(alive, expectedInRegion) {
+					t.Errorf("Frame %v does not match turn %v", i, turn)
+				}
+			}
+		})
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		// Directory of animation cannot be created below a file
+		file := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		var ioError *gol.IOError
+		_, err := record.NewRecorder(p, record.Options{Path: filepath.Join(file, "record", "animation.gif")})
+		if !errors.As(err, &ioError) {
+			t.Errorf("Expected an IOError, got %v", err)
+		}
+	})
+}
+
+// Decode frames of an animated GIF or a numbered PNG sequence
+func readFrames(t *testing.T, path string, count int) []image.Image {
+	var frames []image.Image
+	if filepath.Ext(path) == ".gif" {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		animation, err := gif.DecodeAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, frame := range animation.Image {
+			frames = append(frames, frame)
+		}
+		return frames
+	}
+	for i := 0; i != count; i++ {
+		file, err := os.Open(fmt.Sprintf("%s-%06d.png", path[:len(path)-len(".png")], i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// referenceTurns evaluates p.Turns turns of the given alive cells with a straightforward implementation.
+// It is used to produce expected results for parameters that have no pre-computed check images.
+func referenceTurns(alive []util.Cell, p gol.Params) []util.Cell {
+	rule := p.Rule
+	if !p.RuleSet && rule == (gol.Rule{}) {
+		rule = gol.Conway
+	}
+	world := make([][]bool, p.ImageHeight)
+	next := make([][]bool, p.ImageHeight)
+	for y := range world {
+		world[y] = make([]bool, p.ImageWidth)
+		next[y] = make([]bool, p.ImageWidth)
+	}
+	for _, cell := range alive {
+		world[cell.Y][cell.X] = true
+	}
+	for turn := 0; turn < p.Turns; turn++ {
+		for y := 0; y < p.ImageHeight; y++ {
+			for x := 0; x < p.ImageWidth; x++ {
+				count := 0
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						if dx == 0 && dy == 0 {
+							continue
+						}
+						if neighbour, ok := referenceNeighbour(x+dx, y+dy, p); ok && world[neighbour.Y][neighbour.X] {
+							count++
+						}
+					}
+				}
+				if world[y][x] {
+					next[y][x] = rule.Survive&(1<<count) != 0
+				} else {
+					next[y][x] = rule.Birth&(1<<count) != 0
+				}
+			}
+		}
+		world, next = next, world
+	}
+	var cells []util.Cell
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
+			if world[y][x] {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells
+}
+
+// referenceNeighbour maps a position outside the grid onto the grid according to p.Topology.
+func referenceNeighbour(x, y int, p gol.Params) (util.Cell, bool) {
+	width, height := p.ImageWidth, p.ImageHeight
+	inside := func(v, size int) bool { return v >= 0 && v < size }
+	switch p.Topology {
+	case gol.DeadBorder:
+		if !inside(x, width) || !inside(y, height) {
+			return util.Cell{}, false
+		}
+	case gol.Reflective:
+		if x == -1 {
+			x = 0
+		} else if x == width {
+			x = width - 1
+		}
+		if y == -1 {
+			y = 0
+		} else if y == height {
+			y = height - 1
+		}
+	case gol.KleinBottle:
+		if !inside(y, height) {
+			x = width - 1 - x
+		}
+	case gol.Cylinder:
+		if !inside(y, height) {
+			return util.Cell{}, false
+		}
+	}
+	return util.Cell{X: (x + width) % width, Y: (y + height) % height}, true
+}
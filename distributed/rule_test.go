@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRule tests HighLife, Seeds, Day & Night, Replicator and B/S on 16x16 and 64x64 images on 0, 1 and 100 turns.
+func TestRule(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+	}
+	rules := []string{"B36/S23", "B2/S", "B3678/S34678", "B1357/S1357", "B/S"}
+	for _, p := range tests {
+		initialAlive := readAliveCells(
+			"images/"+fmt.Sprintf("%vx%v.pgm", p.ImageWidth, p.ImageHeight),
+			p.ImageWidth,
+			p.ImageHeight,
+		)
+		for _, rule := range rules {
+			var err error
+			p.Rule, err = gol.ParseRule(rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.RuleSet = true
+			for _, turns := range []int{0, 1, 100} {
+				p.Turns = turns
+				expectedAlive := referenceTurns(initialAlive, p)
+				for _, threads := range []int{1, 4, 8} {
+					p.Threads = threads
+					testName := fmt.Sprintf("%dx%dx%d-%d-%s", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads,
+						strings.ReplaceAll(rule, "/", ""))
+					t.Run(testName, func(t *testing.T) {
+						events := make(chan gol.Event)
+						go gol.Run(p, events, nil)
+						var cells []util.Cell
+						for event := range events {
+							switch e := event.(type) {
+							case gol.FinalTurnComplete:
+								cells = e.Alive
+							}
+						}
+						assertEqualBoard(t, cells, expectedAlive, p)
+					})
+				}
+			}
+		}
+	}
+}
+
+// TestParseRule tests parsing and formatting of rules in B/S notation.
+func TestParseRule(t *testing.T) {
+	valid := map[string]gol.Rule{
+		"B3/S23":       gol.Conway,
+		"b3/s23":       gol.Conway,
+		"S23/B3":       gol.Conway,
+		"B36/S23":      {Birth: 1<<3 | 1<<6, Survive: 1<<2 | 1<<3},
+		"B2/S":         {Birth: 1 << 2},
+		"B3678/S34678": {Birth: 1<<3 | 1<<6 | 1<<7 | 1<<8, Survive: 1<<3 | 1<<4 | 1<<6 | 1<<7 | 1<<8},
+	}
+	for text, expected := range valid {
+		rule, err := gol.ParseRule(text)
+		if err != nil {
+			t.Errorf("ERROR: %q should be parsed, got %v", text, err)
+		} else if rule != expected {
+			t.Errorf("ERROR: %q parsed as %v, expected %v", text, rule, expected)
+		}
+	}
+	for _, text := range []string{"", "B3", "B3/S23/S1", "B9/S23", "X3/S23", "B3/B3"} {
+		if _, err := gol.ParseRule(text); err == nil {
+			t.Errorf("ERROR: %q should be rejected", text)
+		}
+	}
+	if gol.Conway.String() != "B3/S23" {
+		t.Errorf("ERROR: Conway formatted as %v", gol.Conway)
+	}
+}
@@ -66,6 +66,12 @@ sdl:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.ImageOutputComplete:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.CheckpointComplete:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.ErrorEvent:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.RecoveryChange:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.StateChange:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 				if e.NewState == gol.Quitting {
@@ -86,6 +92,12 @@ func RunHeadless(events <-chan gol.Event) {
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), "Final Turn Complete")
 		case gol.ImageOutputComplete:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.CheckpointComplete:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.ErrorEvent:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.RecoveryChange:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 		case gol.StateChange:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			if e.NewState == gol.Quitting {
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestConcurrentSessions tests 16x16, 64x64 and 512x512 images run at the same time on 100 turns using 8 worker threads.
+func TestConcurrentSessions(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16, Turns: 100, Threads: 8},
+		{ImageWidth: 64, ImageHeight: 64, Turns: 100, Threads: 8},
+		{ImageWidth: 512, ImageHeight: 512, Turns: 100, Threads: 8},
+	}
+	results := make([][]util.Cell, len(tests))
+	wg := sync.WaitGroup{}
+	for i, p := range tests {
+		wg.Add(1)
+		go func(i int, p gol.Params) {
+			defer wg.Done()
+			events := make(chan gol.Event)
+			go gol.Run(p, events, nil)
+			for event := range events {
+				switch e := event.(type) {
+				case gol.FinalTurnComplete:
+					results[i] = e.Alive
+				}
+			}
+		}(i, p)
+	}
+	wg.Wait()
+	for i, p := range tests {
+		t.Run(fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads), func(t *testing.T) {
+			expectedAlive := readAliveCells(
+				fmt.Sprintf("check/images/%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, p.Turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			assertEqualBoard(t, results[i], expectedAlive, p)
+		})
+	}
+}
+
+// TestKillSession tests that killing one session with 'k' leaves another session on the broker running.
+func TestKillSession(t *testing.T) {
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 100000000, Threads: 4}
+	run := func() (chan rune, chan gol.Event) {
+		keyPresses := make(chan rune, 1)
+		events := make(chan gol.Event, 1000)
+		go gol.Run(p, events, keyPresses)
+		return keyPresses, events
+	}
+	// Wait for a turn to complete, then drain events until channel is closed or timeout passes
+	waitTurn := func(events chan gol.Event) {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					t.Fatalf("Expected session to keep running")
+				}
+				if _, ok := event.(gol.TurnComplete); ok {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for a turn")
+			}
+		}
+	}
+	waitClosed := func(events chan gol.Event) {
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for session to stop")
+			}
+		}
+	}
+
+	killedKeys, killedEvents := run()
+	otherKeys, otherEvents := run()
+	waitTurn(killedEvents)
+	waitTurn(otherEvents)
+
+	killedKeys <- 'k'
+	waitClosed(killedEvents)
+	waitTurn(otherEvents)
+
+	otherKeys <- 'q'
+	waitClosed(otherEvents)
+}
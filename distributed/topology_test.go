@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestTopology tests every boundary condition on 16x16 and 64x64 images on 0, 1 and 100 turns.
+func TestTopology(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 16, ImageHeight: 16},
+		{ImageWidth: 64, ImageHeight: 64},
+	}
+	topologies := []gol.Topology{gol.Torus, gol.DeadBorder, gol.Reflective, gol.KleinBottle, gol.Cylinder}
+	for _, p := range tests {
+		initialAlive := readAliveCells(
+			"images/"+fmt.Sprintf("%vx%v.pgm", p.ImageWidth, p.ImageHeight),
+			p.ImageWidth,
+			p.ImageHeight,
+		)
+		for _, topology := range topologies {
+			p.Topology = topology
+			for _, turns := range []int{0, 1, 100} {
+				p.Turns = turns
+				expectedAlive := referenceTurns(initialAlive, p)
+				for _, threads := range []int{1, 4, 8, 16} {
+					p.Threads = threads
+					testName := fmt.Sprintf("%dx%dx%d-%d-%v", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads, p.Topology)
+					t.Run(testName, func(t *testing.T) {
+						events := make(chan gol.Event)
+						go gol.Run(p, events, nil)
+						var cells []util.Cell
+						for event := range events {
+							switch e := event.(type) {
+							case gol.FinalTurnComplete:
+								cells = e.Alive
+							}
+						}
+						assertEqualBoard(t, cells, expectedAlive, p)
+					})
+				}
+			}
+		}
+	}
+}
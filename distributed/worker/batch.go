@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Get cells around a partition by distance (rings[d] holds cells at distance d+1, up to given depth)
+// Identical to that in broker so that both agree on ghost zone
+func (matrix *Matrix) getRings(partition Partition, depth int) [][]Cell {
+
+	// Mark cells in partition
+	visited := make([]bool, matrix.width*matrix.height)
+	frontier := make([]Cell, 0, 1024)
+	for _, block := range partition {
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			for x := block.Start.X; x != block.End.X; x++ {
+				visited[y*matrix.width+x] = true
+			}
+		}
+		for x := block.Start.X; x != block.End.X; x++ {
+			frontier = append(frontier, Cell{X: x, Y: block.Start.Y}, Cell{X: x, Y: block.End.Y - 1})
+		}
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			frontier = append(frontier, Cell{X: block.Start.X, Y: y}, Cell{X: block.End.X - 1, Y: y})
+		}
+	}
+
+	// Expand one cell further for each ring
+	rings := make([][]Cell, 0, depth)
+	for d := 0; d != depth; d++ {
+		ring := make([]Cell, 0, len(frontier))
+		for _, cell := range frontier {
+			surroundings, n := matrix.getSurrounding(cell)
+			for _, surrounding := range surroundings[:n] {
+				if !visited[surrounding.Y*matrix.width+surrounding.X] {
+					visited[surrounding.Y*matrix.width+surrounding.X] = true
+					ring = append(ring, surrounding)
+				}
+			}
+		}
+		rings = append(rings, ring)
+		frontier = ring
+	}
+	return rings
+}
+
+// Prepare batch mode (pixels of partition are copied to a grid of whole matrix holding ghost zone as well)
+func (worker *Worker) initBatch(wp WorkerParams) {
+	worker.grid = make([][]uint8, wp.ImageHeight)
+	worker.counts = make([][]int8, wp.ImageHeight)
+	for y := range worker.grid {
+		worker.grid[y] = make([]uint8, wp.ImageWidth)
+		worker.counts[y] = make([]int8, wp.ImageWidth)
+	}
+	for _, block := range wp.Partition {
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			for x := block.Start.X; x != block.End.X; x++ {
+				if wp.Pixels[y][x] != 0 {
+					worker.toggle(Cell{X: x, Y: y})
+				}
+			}
+		}
+	}
+	worker.rings = worker.matrix.getRings(wp.Partition, wp.MaxBatch)
+}
+
+// Flip a cell of grid and update surrounding counts of surrounding cells
+func (worker *Worker) toggle(cell Cell) {
+	worker.grid[cell.Y][cell.X] ^= 255
+	delta := int8(-1)
+	if worker.grid[cell.Y][cell.X] != 0 {
+		delta = 1
+	}
+	surroundings, n := worker.matrix.getSurrounding(cell)
+	for _, surrounding := range surroundings[:n] {
+		worker.counts[surrounding.Y][surrounding.X] += delta
+	}
+}
+
+// Evaluate multiple turns with a ghost zone as deep as the number of turns (batch mode)
+// Cells in ghost zone are evaluated as well, with one ring less every turn, so that cells in partition
+// are exact after the last turn without exchanging boundaries in between
+func (worker *Worker) Batch(args BatchArgs, reply *BatchReply) error {
+
+	start := time.Now()
+	if worker.grid == nil {
+		return errors.New("worker not in batch mode")
+	}
+	if args.Turns < 1 || args.Turns > len(worker.rings) {
+		return errors.New("batch deeper than ghost zone")
+	}
+
+	// Load ghost zone
+	for _, ring := range worker.rings[:args.Turns] {
+		for _, cell := range ring {
+			if worker.grid[cell.Y][cell.X] != 0 {
+				worker.toggle(cell)
+			}
+		}
+	}
+	for _, cell := range decompressFlipped(args.Ghost, worker.wp.SizeInt) {
+		worker.toggle(cell)
+	}
+
+	// Evaluate turns
+	reply.Flipped = make([][]byte, args.Turns)
+	for turn := 0; turn != args.Turns; turn++ {
+		flipped := worker.evaluateRegion(args.Turns - turn - 1)
+		reply.Flipped[turn] = make([]byte, len(flipped)*worker.wp.SizeInt*2)
+		compressFlippedTo(flipped, reply.Flipped[turn], worker.wp.SizeInt)
+	}
+	reply.Elapsed = time.Since(start)
+	return nil
+}
+
+// Evaluate next turn of partition and rings of ghost zone up to given depth
+// Return flipped cells in partition
+func (worker *Worker) evaluateRegion(depth int) []Cell {
+
+	// Check if a cell flips in next turn
+	flips := func(cell Cell) bool {
+		if worker.grid[cell.Y][cell.X] == 0 {
+			return worker.wp.Rule.born(worker.counts[cell.Y][cell.X])
+		}
+		return !worker.wp.Rule.survives(worker.counts[cell.Y][cell.X])
+	}
+
+	// Evaluate each block and each ring concurrently
+	results := make([][]Cell, len(worker.wp.Partition)+depth)
+	wg := sync.WaitGroup{}
+	for i, block := range worker.wp.Partition {
+		wg.Add(1)
+		go func(i int, block Block) {
+			defer wg.Done()
+			flipped := make([]Cell, 0, 64)
+			for y := block.Start.Y; y != block.End.Y; y++ {
+				for x := block.Start.X; x != block.End.X; x++ {
+					if flips(Cell{X: x, Y: y}) {
+						flipped = append(flipped, Cell{X: x, Y: y})
+					}
+				}
+			}
+			results[i] = flipped
+		}(i, block)
+	}
+	for d, ring := range worker.rings[:depth] {
+		wg.Add(1)
+		go func(i int, ring []Cell) {
+			defer wg.Done()
+			flipped := make([]Cell, 0, 64)
+			for _, cell := range ring {
+				if flips(cell) {
+					flipped = append(flipped, cell)
+				}
+			}
+			results[i] = flipped
+		}(len(worker.wp.Partition)+d, ring)
+	}
+	wg.Wait()
+
+	// Apply flipped cells after all of them are found
+	partition_flipped := make([]Cell, 0, 1024)
+	for i, flipped := range results {
+		for _, cell := range flipped {
+			worker.toggle(cell)
+		}
+		if i < len(worker.wp.Partition) {
+			partition_flipped = append(partition_flipped, flipped...)
+		}
+	}
+	return partition_flipped
+}
@@ -12,7 +12,6 @@ import (
 	"net/rpc"
 	"os"
 	"strings"
-	"sync"
 	"testing"
 )
 
@@ -137,11 +136,7 @@ func Benchmark_512_1000(b *testing.B) {
 		}
 	}
 
-	instance := &Worker{
-		running:     new(bool),
-		cond:        sync.NewCond(new(sync.Mutex)),
-		result_chan: make(chan TurnResult),
-	}
+	instance := NewWorker()
 
 	// Register functions
 	rpc.Register(instance)
@@ -167,6 +162,7 @@ func Benchmark_512_1000(b *testing.B) {
 			Threads:           threads,
 			ImageWidth:        512,
 			ImageHeight:       512,
+			Rule:              Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3},
 			Pixels:            matrix,
 			SurroundingCounts: surrounding_counts,
 			Partition:         partition,
@@ -177,8 +173,8 @@ func Benchmark_512_1000(b *testing.B) {
 			for i := 0; i < b.N; i++ {
 				client.Call("Worker.Init", wp, &struct{}{})
 				for turn := 0; turn != 1000; turn++ {
-					var result []byte
-					client.Call("Worker.Next", adjustments, &result)
+					var reply NextReply
+					client.Call("Worker.Next", adjustments, &reply)
 				}
 			}
 		})
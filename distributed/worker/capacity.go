@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Size of matrix and number of turns evaluated to measure speed
+const (
+	speedMatrixSize = 256
+	speedTurns      = 20
+)
+
+// Measure number of cells one core evaluates per second (reported to broker to weight partitions)
+func measureSpeed() float64 {
+
+	// Random matrix with torus topology
+	size := speedMatrixSize
+	pixels := make([][]uint8, size)
+	next := make([][]uint8, size)
+	for y := range pixels {
+		pixels[y] = make([]uint8, size)
+		next[y] = make([]uint8, size)
+		for x := range pixels[y] {
+			if rand.Intn(4) == 0 {
+				pixels[y][x] = 255
+			}
+		}
+	}
+	rule := Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3}
+
+	// Evaluate turns by counting surrounding cells
+	start := time.Now()
+	for turn := 0; turn != speedTurns; turn++ {
+		for y := 0; y != size; y++ {
+			for x := 0; x != size; x++ {
+				count := int8(0)
+				for _, offset := range surroundingOffsets {
+					if pixels[(y+offset.Y+size)%size][(x+offset.X+size)%size] != 0 {
+						count++
+					}
+				}
+				if (pixels[y][x] == 0 && rule.born(count)) || (pixels[y][x] != 0 && rule.survives(count)) {
+					next[y][x] = 255
+				} else {
+					next[y][x] = 0
+				}
+			}
+		}
+		pixels, next = next, pixels
+	}
+	return float64(size*size*speedTurns) / time.Since(start).Seconds()
+}
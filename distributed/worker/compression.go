@@ -14,3 +14,19 @@ func compressFlippedTo(flipped []Cell, dest []byte, size_int int) []byte {
 	}
 	return dest
 }
+
+// Decompress slice of cells (identical to that in broker)
+func decompressFlipped(data []byte, size_int int) []Cell {
+	flipped := make([]Cell, len(data)/(size_int*2))
+	flipped_index := 0
+	for i := 0; i != len(data); i += size_int * 2 {
+		for j := 0; j != size_int; j++ {
+			flipped[flipped_index].X |= int(data[i+j]) << (j << 3)
+		}
+		for j := 0; j != size_int; j++ {
+			flipped[flipped_index].Y |= int(data[i+size_int+j]) << (j << 3)
+		}
+		flipped_index++
+	}
+	return flipped
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"time"
+)
+
+// Find peers having any surrounding cells of boundary cells of assigned partition (direct mode)
+// Return indices of peers each boundary cell should be sent to when flipped
+func getTargets(wp WorkerParams, matrix *Matrix) map[Cell][]int {
+
+	// Function finding index of peer evaluating a cell
+	owner := func(cell Cell) int {
+		for peer_index, peer := range wp.Peers {
+			for _, block := range peer.Partition {
+				if block.Start.X <= cell.X && cell.X < block.End.X &&
+					block.Start.Y <= cell.Y && cell.Y < block.End.Y {
+					return peer_index
+				}
+			}
+		}
+		return wp.Index
+	}
+
+	targets := make(map[Cell][]int)
+	identifyTargets := func(cell Cell) {
+		if _, ok := targets[cell]; ok {
+			return
+		}
+		var cell_targets []int
+		surroundings, n := matrix.getSurrounding(cell)
+	surrounding_loop:
+		for _, surrounding := range surroundings[:n] {
+			peer_index := owner(surrounding)
+			if peer_index == wp.Index {
+				continue
+			}
+			for _, target := range cell_targets {
+				if target == peer_index {
+					continue surrounding_loop
+				}
+			}
+			cell_targets = append(cell_targets, peer_index)
+		}
+		targets[cell] = cell_targets
+	}
+
+	// Iterate boundaries of assigned blocks
+	for _, block := range wp.Partition {
+		for x := block.Start.X; x != block.End.X; x++ {
+			identifyTargets(Cell{X: x, Y: block.Start.Y})
+			identifyTargets(Cell{X: x, Y: block.End.Y - 1})
+		}
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			identifyTargets(Cell{X: block.Start.X, Y: y})
+			identifyTargets(Cell{X: block.End.X - 1, Y: y})
+		}
+	}
+
+	// Drop cells without targets
+	for cell, cell_targets := range targets {
+		if len(cell_targets) == 0 {
+			delete(targets, cell)
+		}
+	}
+	return targets
+}
+
+// Connect to peers receiving boundary flips of this worker (connections are kept for following tasks)
+func (worker *Worker) dialPeers() error {
+	for _, cell_targets := range worker.targets {
+		for _, peer_index := range cell_targets {
+			address := worker.wp.Peers[peer_index].Address
+			if _, ok := worker.peers[address]; ok {
+				continue
+			}
+			client, err := rpc.DialHTTP("tcp", address)
+			if err != nil {
+				return err
+			}
+			worker.peers[address] = client
+			log.Printf("Peer %s connected", address)
+		}
+	}
+	return nil
+}
+
+// Send boundary flips of current turn to peers and wait for all of them received
+// Peers not receiving within timeout are dropped, so that broker can tell a hung peer from this worker
+func (worker *Worker) sendHalos(halos map[int]*Adjustment) error {
+	call_chan := make(chan *rpc.Call, len(halos))
+	addresses := make(map[*rpc.Call]string)
+	for peer_index, adjustment := range halos {
+		address := worker.wp.Peers[peer_index].Address
+		halo := Halo{Generation: worker.wp.Generation, Turn: worker.turn, Adjustment: *adjustment}
+		addresses[worker.peers[address].Go("Worker.Exchange", halo, &struct{}{}, call_chan)] = address
+	}
+	var timeout <-chan time.Time
+	if worker.wp.Timeout > 0 {
+		timer := time.NewTimer(worker.wp.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	drop := func(address string) {
+		// Connect again in next task
+		worker.peers[address].Close()
+		delete(worker.peers, address)
+	}
+	var err error
+	for len(addresses) != 0 {
+		select {
+		case call := <-call_chan:
+			if call.Error != nil {
+				drop(addresses[call])
+				err = call.Error
+			}
+			delete(addresses, call)
+		case <-timeout:
+			for _, address := range addresses {
+				drop(address)
+			}
+			return errors.New("exchange with peers timed out")
+		}
+	}
+	return err
+}
+
+// Receive boundary flips of a peer, which are applied before evaluating the turn following them
+func (worker *Worker) Exchange(halo Halo, reply *struct{}) error {
+	worker.halo_mutex.Lock()
+	defer worker.halo_mutex.Unlock()
+	if halo.Generation != worker.generation {
+		return errors.New("exchange from another assignment")
+	}
+	received, ok := worker.halos[halo.Turn]
+	if !ok {
+		received = new(Adjustment)
+		worker.halos[halo.Turn] = received
+	}
+	received.Increment = append(received.Increment, halo.Adjustment.Increment...)
+	received.Decrement = append(received.Decrement, halo.Adjustment.Decrement...)
+	return nil
+}
+
+// Reply alive cells in assigned partition (collected by broker in direct mode)
+func (worker *Worker) Collect(_ struct{}, alive_data *[]byte) error {
+	alive := make([]Cell, 0, 1024)
+	for _, block := range worker.wp.Partition {
+		for y := block.Start.Y; y != block.End.Y; y++ {
+			for x := block.Start.X; x != block.End.X; x++ {
+				if worker.matrix.pixels[y][x] != 0 {
+					alive = append(alive, Cell{X: x, Y: y})
+				}
+			}
+		}
+	}
+	*alive_data = make([]byte, len(alive)*worker.wp.SizeInt*2)
+	compressFlippedTo(alive, *alive_data, worker.wp.SizeInt)
+	return nil
+}
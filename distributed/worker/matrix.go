@@ -6,6 +6,8 @@ type Matrix struct {
 	pixels             [][]uint8
 	surrounding_counts [][]int8
 	partition          Partition
+	rule               Rule
+	topology           Topology
 }
 
 // Make matrix object with empty data
@@ -16,8 +18,10 @@ func MakeMatrix(wp WorkerParams) Matrix {
 		pixels:             make([][]uint8, wp.ImageHeight),
 		surrounding_counts: make([][]int8, wp.ImageHeight),
 		partition:          wp.Partition,
+		rule:               wp.Rule,
+		topology:           wp.Topology,
 	}
-	for i := 0; i != matrix.width; i++ {
+	for i := 0; i != matrix.height; i++ {
 		matrix.pixels[i] = make([]uint8, len(wp.Pixels[i]))
 		matrix.surrounding_counts[i] = make([]int8, len(wp.Pixels[i]))
 	}
@@ -33,22 +37,16 @@ func MakeMatrixFromData(wp WorkerParams) Matrix {
 		pixels:             wp.Pixels,
 		surrounding_counts: wp.SurroundingCounts,
 		partition:          wp.Partition,
+		rule:               wp.Rule,
+		topology:           wp.Topology,
 	}
 }
 
-// Get positions of eight surrounding cells
-func (matrix *Matrix) getSurrounding(cell Cell) [8]Cell {
+// Get positions of surrounding cells and the number of them
+// Cells at the edges of the grid may have less than eight surrounding cells depending on topology
+func (matrix *Matrix) getSurrounding(cell Cell) ([8]Cell, int) {
 	if cell.X == 0 || cell.Y == 0 || cell.X == matrix.width-1 || cell.Y == matrix.height-1 {
-		return [8]Cell{
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: cell.X, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: (cell.X + 1) % matrix.width, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: cell.Y},
-			{X: (cell.X + 1) % matrix.width, Y: cell.Y},
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: (cell.Y + 1) % matrix.height},
-			{X: cell.X, Y: (cell.Y + 1) % matrix.height},
-			{X: (cell.X + 1) % matrix.width, Y: (cell.Y + 1) % matrix.height},
-		}
+		return matrix.topology.surrounding(matrix.width, matrix.height, cell)
 	} else {
 		return [8]Cell{
 			{X: cell.X - 1, Y: cell.Y - 1},
@@ -59,7 +57,7 @@ func (matrix *Matrix) getSurrounding(cell Cell) [8]Cell {
 			{X: cell.X - 1, Y: cell.Y + 1},
 			{X: cell.X, Y: cell.Y + 1},
 			{X: cell.X + 1, Y: cell.Y + 1},
-		}
+		}, 8
 	}
 }
 
@@ -68,10 +66,11 @@ func (matrix *Matrix) getSurrounding(cell Cell) [8]Cell {
 // Return alive cell count difference
 func (matrix *Matrix) checkAndFlip(cell Cell, next_matrix *Matrix, flipping_buffer *[]Cell) {
 	if matrix.pixels[cell.Y][cell.X] == 0 {
-		if matrix.surrounding_counts[cell.Y][cell.X] == 3 {
+		if matrix.rule.born(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 255
-			for _, surrounding := range matrix.getSurrounding(cell) {
+			surroundings, n := matrix.getSurrounding(cell)
+			for _, surrounding := range surroundings[:n] {
 				next_matrix.surrounding_counts[surrounding.Y][surrounding.X]++
 			}
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -80,16 +79,14 @@ func (matrix *Matrix) checkAndFlip(cell Cell, next_matrix *Matrix, flipping_buff
 			next_matrix.pixels[cell.Y][cell.X] = 0
 		}
 	} else {
-		switch matrix.surrounding_counts[cell.Y][cell.X] {
-		case 2:
-			fallthrough
-		case 3:
+		if matrix.rule.survives(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Copying
 			next_matrix.pixels[cell.Y][cell.X] = 255
-		default:
+		} else {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 0
-			for _, surrounding := range matrix.getSurrounding(cell) {
+			surroundings, n := matrix.getSurrounding(cell)
+			for _, surrounding := range surroundings[:n] {
 				next_matrix.surrounding_counts[surrounding.Y][surrounding.X]--
 			}
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -101,7 +98,7 @@ func (matrix *Matrix) checkAndFlip(cell Cell, next_matrix *Matrix, flipping_buff
 // Return alive cell count difference
 func (matrix *Matrix) checkAndFlipUnsafe(cell Cell, next_matrix *Matrix, flipping_buffer *[]Cell, unsafe_buffer *[]Cell) {
 	if matrix.pixels[cell.Y][cell.X] == 0 {
-		if matrix.surrounding_counts[cell.Y][cell.X] == 3 {
+		if matrix.rule.born(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 255
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -111,13 +108,10 @@ func (matrix *Matrix) checkAndFlipUnsafe(cell Cell, next_matrix *Matrix, flippin
 			next_matrix.pixels[cell.Y][cell.X] = 0
 		}
 	} else {
-		switch matrix.surrounding_counts[cell.Y][cell.X] {
-		case 2:
-			fallthrough
-		case 3:
+		if matrix.rule.survives(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Copying
 			next_matrix.pixels[cell.Y][cell.X] = 255
-		default:
+		} else {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 0
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -140,13 +134,15 @@ func (matrix *Matrix) inPartition(cell Cell) bool {
 // Update surrounding counts of surrounding cells for those in assigned partition
 func (matrix *Matrix) updateUnsafe(cell Cell, next_matrix *Matrix) {
 	if matrix.pixels[cell.Y][cell.X] == 0 {
-		for _, surrounding := range matrix.getSurrounding(cell) {
+		surroundings, n := matrix.getSurrounding(cell)
+		for _, surrounding := range surroundings[:n] {
 			if matrix.inPartition(surrounding) {
 				next_matrix.surrounding_counts[surrounding.Y][surrounding.X]++
 			}
 		}
 	} else {
-		for _, surrounding := range matrix.getSurrounding(cell) {
+		surroundings, n := matrix.getSurrounding(cell)
+		for _, surrounding := range surroundings[:n] {
 			if matrix.inPartition(surrounding) {
 				next_matrix.surrounding_counts[surrounding.Y][surrounding.X]--
 			}
@@ -157,14 +153,16 @@ func (matrix *Matrix) updateUnsafe(cell Cell, next_matrix *Matrix) {
 // Update surrounding counts of surrounding cells of cells not in partition
 func (matrix *Matrix) applyAdjustment(adjustment Adjustment) {
 	for _, cell := range adjustment.Increment {
-		for _, surrounding := range matrix.getSurrounding(cell) {
+		surroundings, n := matrix.getSurrounding(cell)
+		for _, surrounding := range surroundings[:n] {
 			if matrix.inPartition(surrounding) {
 				matrix.surrounding_counts[surrounding.Y][surrounding.X]++
 			}
 		}
 	}
 	for _, cell := range adjustment.Decrement {
-		for _, surrounding := range matrix.getSurrounding(cell) {
+		surroundings, n := matrix.getSurrounding(cell)
+		for _, surrounding := range surroundings[:n] {
 			if matrix.inPartition(surrounding) {
 				matrix.surrounding_counts[surrounding.Y][surrounding.X]--
 			}
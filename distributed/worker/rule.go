@@ -0,0 +1,19 @@
+package main
+
+// Life-like rule in B/S notation (identical to that in gol)
+// Bit n of Birth is set if a dead cell with n alive neighbours becomes alive,
+// bit n of Survive is set if an alive cell with n alive neighbours stays alive.
+type Rule struct {
+	Birth   uint16
+	Survive uint16
+}
+
+// Check if a dead cell with given number of alive neighbours becomes alive
+func (rule *Rule) born(count int8) bool {
+	return rule.Birth&(1<<uint8(count)) != 0
+}
+
+// Check if an alive cell with given number of alive neighbours stays alive
+func (rule *Rule) survives(count int8) bool {
+	return rule.Survive&(1<<uint8(count)) != 0
+}
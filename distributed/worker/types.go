@@ -2,6 +2,8 @@
 
 package main
 
+import "time"
+
 type Cell struct {
 	X, Y int
 }
@@ -18,12 +20,47 @@ type WorkerParams struct {
 	Threads           int
 	ImageWidth        int
 	ImageHeight       int
-	Pixels            [][]uint8 // Incomplete 2D slice storing pixels
-	SurroundingCounts [][]int8  // Incomplete 2D slice storing surrounding counts
-	Partition         Partition // Assigned task partition
-	SizeInt           int       // Minimum number of bytes to represent the whole range of width and height
+	Rule              Rule          // Life-like rule
+	Topology          Topology      // Boundary condition at the edges of the grid
+	Pixels            [][]uint8     // Incomplete 2D slice storing pixels
+	SurroundingCounts [][]int8      // Incomplete 2D slice storing surrounding counts
+	Partition         Partition     // Assigned task partition
+	SizeInt           int           // Minimum number of bytes to represent the whole range of width and height
+	Direct            bool          // Send boundary flips to peers directly instead of returning them to broker
+	Index             int           // Index of assigned partition in peers
+	Peers             []Peer        // Partitions of all worker nodes of the session (used in direct mode)
+	Generation        uint64        // ID of this assignment (exchanges from other assignments are rejected)
+	MaxBatch          int           // Depth of ghost zone in batch mode (one turn per RPC when less than two)
+	Timeout           time.Duration // Time peers may take to receive boundary flips in direct mode
+}
+
+// Partition evaluated by another worker node of the same session
+type Peer struct {
+	Address   string // Address of RPC service of worker node
+	Partition Partition
+}
+
+// Boundary flips of one turn sent directly from a worker node to a peer
+type Halo struct {
+	Generation uint64
+	Turn       int // Number of turns completed by sender since assigned
+	Adjustment Adjustment
+}
+
+// Message sent by worker node when registering to broker
+type Registration struct {
+	RPCPort int     // Port of worker RPC service
+	Cores   int     // Number of CPUs usable by worker
+	Speed   float64 // Cells evaluated per second by one core (measured on start)
 }
 
+// Bytes written by worker node on registration connection
+const (
+	DRAIN_REQUEST  = 1 // Request to be drained (written back by broker once no session uses the node)
+	HEARTBEAT      = 2 // Written periodically to show the node is alive
+	DRAIN_REJECTED = 3 // Written by broker when the node is the last one left to a running session
+)
+
 type TurnResult struct {
 	flipped        []Cell // Slice of all the flipping cells
 	unsafe_flipped []Cell // Slice of flipping cells at unsafe boundaries (cells flipped but surrounding counts not updated)
@@ -34,3 +71,21 @@ type Adjustment struct {
 	Increment []Cell // Surrounding counts of surrounding cells in the slice should be incremented
 	Decrement []Cell // Surrounding counts of surrounding cells in the slice should be decremented
 }
+
+// Result of evaluating one turn
+type NextReply struct {
+	Flipped []byte        // Compressed flipped cells (nil in direct mode)
+	Elapsed time.Duration // Time spent evaluating (used by broker to weight partitions)
+}
+
+// Request of evaluating multiple turns in one RPC (batch mode)
+type BatchArgs struct {
+	Turns int    // Number of turns to evaluate (not deeper than ghost zone)
+	Ghost []byte // Compressed positions of alive cells in ghost zone up to the depth of number of turns
+}
+
+// Cells in partition flipped in each turn of a batch
+type BatchReply struct {
+	Flipped [][]byte      // Compressed flipped cells of each turn
+	Elapsed time.Duration // Time spent evaluating (subtracted from call time to measure round-trip time)
+}
@@ -1,22 +1,35 @@
 package main
 
 import (
+	"encoding/gob"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"runtime"
 	"sync"
+	"syscall"
 	"time"
 )
 
 func main() {
-	// Create worker instance
-	instance := &Worker{
-		running:     new(bool),
-		cond:        sync.NewCond(new(sync.Mutex)),
-		result_chan: make(chan TurnResult),
-		flag:        sync.WaitGroup{},
+	// Load configuration
+	config_flags := RegisterConfigFlags(flag.CommandLine)
+	heartbeat_interval := flag.Duration(
+		"heartbeat",
+		time.Second,
+		"Specify the time between heartbeats sent to broker. Defaults to 1s.")
+	flag.Parse()
+	config, err := config_flags.Load()
+	if err != nil {
+		log.Fatal(err.Error())
 	}
+
+	// Create worker instance
+	instance := NewWorker()
 	instance.flag.Add(1)
 
 	// Register functions
@@ -24,19 +37,43 @@ func main() {
 	rpc.HandleHTTP()
 
 	// Start RPC handling service
-	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: 2000})
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.WorkerRPCPort})
 	if err != nil {
 		log.Panic(err.Error())
 	}
 	go http.Serve(listener, nil)
 
-	// Registering worker node to broker
+	// Registering worker node to broker with its capacity
+	registration := Registration{
+		RPCPort: config.WorkerRPCPort,
+		Cores:   runtime.GOMAXPROCS(0),
+		Speed:   measureSpeed(),
+	}
+	log.Printf("Capacity: %d cores, %.0f cells/s per core", registration.Cores, registration.Speed)
+	// Drain on first SIGTERM or SIGINT and exit immediately on second
+	drain_chan := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		<-signals
+		log.Print("Draining")
+		close(drain_chan)
+		<-signals
+		log.Fatal("Exit without draining")
+	}()
+
 	go func() {
 		for {
 			var conn *net.TCPConn
 			for {
-				log.Print("Registering worker to broker")
-				conn, err = net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(172, 31, 46, 226), Port: 2002})
+				select {
+				case <-drain_chan:
+					instance.exit()
+					return
+				default:
+				}
+				log.Printf("Registering worker to broker %s", config.registerAddr())
+				conn, err = dialBroker(config.registerAddr(), registration)
 				if err == nil {
 					log.Print("Worker registered")
 					break
@@ -45,15 +82,79 @@ func main() {
 			}
 			conn.SetKeepAlive(true)
 			conn.SetReadDeadline(*new(time.Time))
-			conn.Read(make([]byte, 1))
-			log.Print("Broker disconnected")
-			conn.Close()
+			go func() {
+				for {
+					time.Sleep(*heartbeat_interval)
+					if _, err := conn.Write([]byte{HEARTBEAT}); err != nil {
+						return
+					}
+				}
+			}()
+			replies := make(chan byte, 1)
+			go func() {
+				defer close(replies)
+				reply := make([]byte, 1)
+				for {
+					if _, err := conn.Read(reply); err != nil {
+						return
+					}
+					replies <- reply[0]
+				}
+			}()
+			drain := drain_chan
+		serve:
+			for {
+				select {
+				case _, ok := <-replies:
+					if !ok {
+						log.Print("Broker disconnected")
+						conn.Close()
+						break serve
+					}
+				case <-drain:
+					// Broker replies once partitions of this worker are moved to other workers
+					conn.Write([]byte{DRAIN_REQUEST})
+					reply, ok := <-replies
+					if ok && reply == DRAIN_REJECTED {
+						// Keep serving the session left without other worker nodes
+						log.Print("Drain rejected: last worker node of a running session (interrupt again to exit)")
+						drain = nil
+						continue
+					}
+					if !ok {
+						log.Print("Broker disconnected while draining")
+					} else {
+						log.Print("Drained")
+					}
+					conn.Close()
+					instance.exit()
+					return
+				}
+			}
 		}
 	}()
 
 	instance.flag.Wait()
 }
 
+// Connect to broker and report address of RPC service
+func dialBroker(address string, registration Registration) (*net.TCPConn, error) {
+	tcp_addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTCP("tcp", nil, tcp_addr)
+	if err != nil {
+		return nil, err
+	}
+	err = gob.NewEncoder(conn).Encode(registration)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
 type Worker struct {
 	wp          WorkerParams
 	matrix      Matrix
@@ -62,7 +163,31 @@ type Worker struct {
 	cond        *sync.Cond
 	result_chan chan TurnResult
 
-	flag sync.WaitGroup
+	// Direct exchange of boundary flips between worker nodes
+	targets    map[Cell][]int         // Peers receiving flips of each boundary cell
+	peers      map[string]*rpc.Client // Connections to peers by address
+	halo_mutex *sync.Mutex            // Synchronise access to received boundary flips and generation
+	halos      map[int]*Adjustment    // Boundary flips received from peers by number of turns completed
+	generation uint64                 // Generation of current assignment
+	turn       int                    // Number of turns completed since assigned
+
+	// Evaluation of multiple turns per RPC
+	grid   [][]uint8 // Pixels of partition and ghost zone (nil if not in batch mode)
+	counts [][]int8  // Surrounding counts of cells in grid
+	rings  [][]Cell  // Cells of ghost zone by distance from partition
+
+	flag      sync.WaitGroup
+	exit_once sync.Once
+}
+
+func NewWorker() *Worker {
+	return &Worker{
+		running:     new(bool),
+		cond:        sync.NewCond(new(sync.Mutex)),
+		result_chan: make(chan TurnResult),
+		peers:       make(map[string]*rpc.Client),
+		halo_mutex:  new(sync.Mutex),
+	}
 }
 
 type LogicWorkerParams struct {
@@ -78,17 +203,44 @@ type LogicWorkerParams struct {
 func (worker *Worker) Init(wp WorkerParams, reply *struct{}) error {
 
 	log.Printf("Init: %dx%dx%d-%d (%d blocks assigned)",
-		wp.ImageWidth, wp.ImageWidth, wp.Turns, wp.Threads, len(wp.Partition))
+		wp.ImageWidth, wp.ImageHeight, wp.Turns, wp.Threads, len(wp.Partition))
 
-	// Cancel last task if not completed
+	// Cancel last task if not completed (logic workers read running flag while holding lock)
+	worker.cond.L.Lock()
 	*worker.running = false
 	worker.cond.Broadcast()
+	worker.cond.L.Unlock()
 
 	// Load matrix data
 	worker.wp = wp
 	worker.matrix = MakeMatrixFromData(wp)
 	worker.next_matrix = MakeMatrix(wp)
 
+	// Reject boundary flips of last task
+	worker.halo_mutex.Lock()
+	worker.generation = wp.Generation
+	worker.halos = make(map[int]*Adjustment)
+	worker.turn = 0
+	worker.halo_mutex.Unlock()
+
+	// Find and connect to peers in direct mode
+	worker.targets = nil
+	if wp.Direct {
+		worker.targets = getTargets(wp, &worker.matrix)
+		if err := worker.dialPeers(); err != nil {
+			return err
+		}
+	}
+
+	// Evaluate in batches without logic workers in batch mode
+	worker.grid = nil
+	worker.counts = nil
+	worker.rings = nil
+	if wp.MaxBatch > 1 {
+		worker.initBatch(wp)
+		return nil
+	}
+
 	// Reset worker instance status
 	worker.running = new(bool)
 	*worker.running = true
@@ -110,10 +262,20 @@ func (worker *Worker) Init(wp WorkerParams, reply *struct{}) error {
 	return nil
 }
 
-func (worker *Worker) Next(adjustment Adjustment, flipped_data *[]byte) error {
+func (worker *Worker) Next(adjustment Adjustment, reply *NextReply) error {
+
+	start := time.Now()
 
 	// Apply adjustments from other boundaries of other partitions
 	worker.matrix.applyAdjustment(adjustment)
+	if worker.wp.Direct {
+		worker.halo_mutex.Lock()
+		if halo, ok := worker.halos[worker.turn]; ok {
+			worker.matrix.applyAdjustment(*halo)
+			delete(worker.halos, worker.turn)
+		}
+		worker.halo_mutex.Unlock()
+	}
 
 	// Broadcast as critical section to prevent any routine not in waiting state before broadcast
 	worker.cond.L.Lock()
@@ -127,13 +289,39 @@ func (worker *Worker) Next(adjustment Adjustment, flipped_data *[]byte) error {
 	}
 
 	// All routines completed current turn
+	// In direct mode, boundary flips are sent to peers instead of returning flipped cells
+	if worker.wp.Direct {
+		halos := make(map[int]*Adjustment)
+		for thread_index := 0; thread_index != len(worker.wp.Partition); thread_index++ {
+			for _, cell := range result_buffer[thread_index].unsafe_flipped {
+				for _, peer_index := range worker.targets[cell] {
+					halo, ok := halos[peer_index]
+					if !ok {
+						halo = new(Adjustment)
+						halos[peer_index] = halo
+					}
+					if worker.matrix.pixels[cell.Y][cell.X] == 0 {
+						halo.Increment = append(halo.Increment, cell)
+					} else {
+						halo.Decrement = append(halo.Decrement, cell)
+					}
+				}
+				worker.matrix.updateUnsafe(cell, &worker.next_matrix)
+			}
+		}
+		worker.matrix, worker.next_matrix = worker.next_matrix, worker.matrix
+		worker.turn++
+		reply.Elapsed = time.Since(start)
+		return worker.sendHalos(halos)
+	}
+
 	// Collect flipping flipping cells and update unsafe boundaries
 	flipped_total := 0
 	for thread_index := 0; thread_index != len(worker.wp.Partition); thread_index++ {
 		flipped_total += len(result_buffer[thread_index].flipped)
 	}
-	*flipped_data = make([]byte, flipped_total*worker.wp.SizeInt*2)
-	flipped_data_view := (*flipped_data)[:]
+	reply.Flipped = make([]byte, flipped_total*worker.wp.SizeInt*2)
+	flipped_data_view := reply.Flipped[:]
 	for thread_index := 0; thread_index != len(worker.wp.Partition); thread_index++ {
 		turn_result := result_buffer[thread_index]
 		flipped_data_view = compressFlippedTo(turn_result.flipped, flipped_data_view, worker.wp.SizeInt)
@@ -144,17 +332,28 @@ func (worker *Worker) Next(adjustment Adjustment, flipped_data *[]byte) error {
 
 	// Swap current and next matrix
 	worker.matrix, worker.next_matrix = worker.next_matrix, worker.matrix
+	reply.Elapsed = time.Since(start)
+
+	return nil
+}
 
+// Reply immediately (used by broker to measure round-trip time)
+func (worker *Worker) Ping(struct{}, *struct{}) error {
 	return nil
 }
 
 func (worker *Worker) Kill(struct{}, *struct{}) error {
 
 	log.Print("Kill")
-	worker.flag.Done()
+	worker.exit()
 	return nil
 }
 
+// Let main return (once, as both broker and signals may stop worker)
+func (worker *Worker) exit() {
+	worker.exit_once.Do(worker.flag.Done)
+}
+
 func logic_worker(lwp LogicWorkerParams) {
 
 	// Wait until Init routine finishes initialisation
@@ -163,10 +362,11 @@ func logic_worker(lwp LogicWorkerParams) {
 	lwp.cond.L.Lock()
 	lwp.result_chan <- TurnResult{} // notify distributor that this routine is ready
 	lwp.cond.Wait()
+	running := *lwp.running
 	lwp.cond.L.Unlock()
 
 	// Work for each turn
-	for *lwp.running {
+	for running {
 
 		// Update surrounding counts
 		for y := lwp.start.Y; y != lwp.end.Y; y++ {
@@ -216,6 +416,7 @@ func logic_worker(lwp LogicWorkerParams) {
 
 		// Wait for other workers completing current turn
 		lwp.cond.Wait()
+		running = *lwp.running
 		lwp.cond.L.Unlock()
 	}
 }
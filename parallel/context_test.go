@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestRunContext tests that a 512x512 image run stops early when its context is cancelled or its deadline passes.
+func TestRunContext(t *testing.T) {
+	p := gol.Params{ImageWidth: 512, ImageHeight: 512, Turns: 100000000, Threads: 8}
+	tests := []struct {
+		name     string
+		save     bool
+		expected error
+		start    func() (context.Context, context.CancelFunc)
+	}{
+		{"cancel", false, context.Canceled, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(time.Second, cancel)
+			return ctx, cancel
+		}},
+		{"deadline", true, context.DeadlineExceeded, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), time.Second)
+		}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s-%dx%d", test.name, p.ImageWidth, p.ImageHeight), func(t *testing.T) {
+			p := p
+			p.SaveOnCancel = test.save
+			ctx, cancel := test.start()
+			defer cancel()
+			events := make(chan gol.Event)
+			result := make(chan error, 1)
+			go func() { result <- gol.RunContext(ctx, p, events, nil) }()
+
+			final := false
+			quitting := false
+			outputs := 0
+			for event := range events {
+				switch e := event.(type) {
+				case gol.FinalTurnComplete:
+					final = true
+					if e.CompletedTurns == 0 || e.CompletedTurns >= p.Turns {
+						t.Errorf("Expected run to stop early, stopped at turn %v", e.CompletedTurns)
+					}
+				case gol.ImageOutputComplete:
+					outputs++
+				case gol.StateChange:
+					quitting = e.NewState == gol.Quitting
+				}
+			}
+			if !final || !quitting {
+				t.Errorf("Expected FinalTurnComplete and Quitting after cancelling")
+			}
+			if test.save != (outputs != 0) {
+				t.Errorf("Expected final image written to be %v, got %v images", test.save, outputs)
+			}
+			if err := <-result; !errors.Is(err, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
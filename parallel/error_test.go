@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestErrors tests that a missing image and a bad checkpoint are reported as typed errors instead of panics.
+func TestErrors(t *testing.T) {
+	t.Run("missing image", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 48, ImageHeight: 48, Turns: 1, Threads: 1}
+		var ioError *gol.IOError
+		err, reported := runFailing(t, p)
+		if !errors.As(err, &ioError) || !errors.As(reported, &ioError) {
+			t.Errorf("Expected an IOError, got %v (reported %v)", err, reported)
+		}
+	})
+
+	t.Run("bad checkpoint", func(t *testing.T) {
+		p := gol.Params{ImageWidth: 16, ImageHeight: 16, Turns: 1, Threads: 1, Resume: "images/16x16.pgm"}
+		var inputError *gol.InputError
+		err, reported := runFailing(t, p)
+		if !errors.As(err, &inputError) || !errors.As(reported, &inputError) {
+			t.Errorf("Expected an InputError, got %v (reported %v)", err, reported)
+		}
+	})
+}
+
+// Run until events channel is closed and return the error returned by Run and the one reported as an event
+func runFailing(t *testing.T, p gol.Params) (error, error) {
+	events := make(chan gol.Event)
+	result := make(chan error, 1)
+	go func() { result <- gol.Run(p, events, nil) }()
+	var reported error
+	quitting := false
+	for event := range events {
+		switch e := event.(type) {
+		case gol.ErrorEvent:
+			reported = e.Err
+		case gol.FinalTurnComplete:
+			t.Errorf("Expected no final turn after a failed input, got %v", e)
+		case gol.StateChange:
+			quitting = e.NewState == gol.Quitting
+		}
+	}
+	if !quitting {
+		t.Errorf("Expected Quitting as the last state")
+	}
+	return <-result, reported
+}
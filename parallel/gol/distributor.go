@@ -1,6 +1,7 @@
 package gol
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"sync"
@@ -20,10 +21,11 @@ type WorkerParams struct {
 	next_matrix Matrix            // Write to this matrix
 	start       util.Cell         // Top-left corner of cell partition allocated
 	end         util.Cell         // Bottom-right corner of cell partition allocated (not inclusive)
+	turn        int               // Number of completed turns when routine is created
 	running     *bool             // Volatile variable to instruct routines to stop when set to false (read-write protected by condition variable)
-	cond        *sync.Cond        // Condition variable for worker routines wait for distributor collecting results
-	result_chan chan<- TurnResult // Result send to distributor after each turn
-	event_chan  chan<- Event      // CellsFlipped event channel
+	cond        *sync.Cond        // Condition variable for worker routines wait for simulator collecting results
+	result_chan chan<- TurnResult // Result send to simulator after each turn
+	event_chan  chan<- Event      // CellsFlipped event channel (nil when not sent)
 }
 
 type TurnResult struct {
@@ -32,73 +34,64 @@ type TurnResult struct {
 }
 
 // distributor divides the work between workers and interacts with other goroutines.
-func distributor(p Params, io *ioState, c distributorChannels) {
+// Return the first failure reported to the user, after events channel is closed
+func distributor(ctx context.Context, p Params, io *ioState, c distributorChannels) error {
 
 	defer io.quit()
 
-	// Read file
+	// Read file (or checkpoint when resuming, or pattern)
 	operation := ioOperation{
 		command:  ioInput,
-		filename: fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight),
+		filename: fmt.Sprintf("images/%dx%d.pgm", p.ImageWidth, p.ImageHeight),
+	}
+	if p.Input != "" {
+		operation.filename = p.Input
+	}
+	if p.Resume != "" {
+		operation = ioOperation{
+			command:  ioCheckpointInput,
+			filename: p.Resume,
+		}
+	} else if p.Pattern != "" {
+		operation = ioOperation{
+			command:  ioPatternInput,
+			filename: p.Pattern,
+		}
 	}
 	io.sendIoRequest(&operation)
-	io.waitIoRequest() // Wait for last pending request completing
+	if err := io.waitIoRequest(&operation); err != nil {
+		return abort(c.events, 0, err)
+	}
+	turn := operation.turn
 
-	// Create cell matrix and load pixel data
-	matrix := MakeMatrixFromData(p, operation.data)
-	next_matrix := MakeMatrix(p)
-	count := 0
-	{
-		flipping_buffer := make([]util.Cell, 1024)
-		for i := 0; i != p.ImageHeight; i++ {
-			for j := 0; j != p.ImageWidth; j++ {
-				if matrix.pixels[i][j] != 0 {
-					count++
-					flipping_buffer = append(flipping_buffer, util.Cell{X: j, Y: i})
-					for _, cell := range matrix.getSurrounding(util.Cell{X: j, Y: i}) {
-						matrix.surrounding_counts[cell.Y][cell.X]++
-					}
-				}
-			}
-		}
-		c.events <- CellsFlipped{0, flipping_buffer}
-	} // This scope removes flipping_buffer reference to help garbage collection
+	// Create simulator and its goroutines
+	sim := newSimulator(p, operation.data, turn, c.events)
+	c.events <- CellsFlipped{turn, sim.AliveCells()}
 
-	// Create goroutines
-	blocks := divideToBlocks(p)
-	nthread := len(blocks)
-	running_flag := true // Exit all routines when set to false
-	pause_flag := false  // Skip cond.broadcast when set to true
-	cond := sync.NewCond(new(sync.Mutex))
-	result_chan := make(chan TurnResult)
-	result_buffer := make([]TurnResult, nthread)
-	for i := 0; i != nthread; i++ {
-		wp := WorkerParams{
-			p:           p,
-			matrix:      matrix,
-			next_matrix: next_matrix,
-			start:       blocks[i].start,
-			end:         blocks[i].end,
-			running:     &running_flag,
-			cond:        cond,
-			result_chan: result_chan,
-			event_chan:  c.events,
+	// Failures of writing files are reported without stopping evaluation
+	var failure error
+	report := func(turn int, err error) {
+		if failure == nil {
+			failure = err
 		}
-		go worker(wp)
-		<-result_chan // Make sure goroutine is ready
+		c.events <- ErrorEvent{turn, err}
 	}
 
-	// Write file function
-	write := func(turn int) {
-		filename := fmt.Sprintf("%dx%dx%d", p.ImageWidth, p.ImageHeight, turn)
-		operation := &ioOperation{
-			command:  ioOutput,
-			filename: filename,
-			data:     matrix.pixels[0][0 : p.ImageWidth*p.ImageHeight],
-		}
-		io.sendIoRequest(operation)
-		io.waitIoRequest() // Wait for last pending request completing
-		c.events <- ImageOutputComplete{turn, filename}
+	// Snapshots are copied so that evaluation continues while files are written
+	snapshots := &snapshotWriter{
+		io:      io,
+		events:  c.events,
+		report:  report,
+		started: time.Now(),
+		keep:    p.SnapshotKeep,
+	}
+
+	// Timer of automatic snapshots (never ready when disabled)
+	var snapshot_timer <-chan time.Time
+	if p.SnapshotPeriod > 0 {
+		snapshot_ticker := time.NewTicker(p.SnapshotPeriod)
+		defer snapshot_ticker.Stop()
+		snapshot_timer = snapshot_ticker.C
 	}
 
 	// Alive timer
@@ -106,97 +99,88 @@ func distributor(p Params, io *ioState, c distributorChannels) {
 	defer ticker.Stop()
 
 	// Evaluate each turn
-	turn := 0
 	c.events <- StateChange{turn, Executing}
-	for turn != p.Turns {
-		// Broadcast as critical section to prevent any routine not in waiting state before broadcast
-		cond.L.Lock()
-		cond.Broadcast()
-		cond.L.Unlock()
-		// Get results for current turn
-		for thread_index := 0; thread_index != nthread; thread_index++ {
-			result_buffer[thread_index] = <-result_chan
-		}
-		// All routines completed current turn
-		for thread_index := 0; thread_index != nthread; thread_index++ {
-			count += result_buffer[thread_index].count_diff
-			for _, cell := range result_buffer[thread_index].unsafe_flipped {
-				if matrix.pixels[cell.Y][cell.X] == 0 {
-					for _, surrounding := range matrix.getSurrounding(cell) {
-						next_matrix.surrounding_counts[surrounding.Y][surrounding.X]++
-					}
-				} else {
-					for _, surrounding := range matrix.getSurrounding(cell) {
-						next_matrix.surrounding_counts[surrounding.Y][surrounding.X]--
-					}
-				}
-			}
-		}
-		// Swap current and next matrix
-		matrix, next_matrix = next_matrix, matrix
+	for turn < p.Turns {
+		sim.Step(1)
 		// Turn completed
 		turn++
 		c.events <- TurnComplete{turn}
+		if p.CheckpointInterval > 0 && turn%p.CheckpointInterval == 0 {
+			snapshots.save(turn, sim.pixels(), false, true)
+		}
+		if p.SnapshotInterval > 0 && turn%p.SnapshotInterval == 0 {
+			snapshots.autosave(turn, sim.pixels())
+		}
 		// Handle events
 	handle:
 		select {
+		case <-ctx.Done():
+			goto quit
 		case <-ticker.C:
-			c.events <- AliveCellsCount{turn, count}
+			c.events <- AliveCellsCount{turn, sim.AliveCount()}
+		case <-snapshot_timer:
+			snapshots.autosave(turn, sim.pixels())
 		case char := <-c.keyPresses:
 			switch char {
 			case 's':
-				write(turn)
+				snapshots.save(turn, sim.pixels(), true, true)
 			case 'q':
 				goto quit
 			case 'p':
-				pause_flag = !pause_flag
-				if pause_flag {
-					c.events <- StateChange{turn, Paused}
-				} else {
+				if sim.Paused() {
+					sim.Resume()
 					c.events <- StateChange{turn, Executing}
+				} else {
+					sim.Pause()
+					c.events <- StateChange{turn, Paused}
 				}
 			}
 		default:
 		}
-		if pause_flag {
+		snapshots.poll()
+		if sim.Paused() {
 			goto handle
 		}
 	}
 
 quit:
-	// Set flag variable to exit all worker routines
-	cond.L.Lock()
-	running_flag = false
-	cond.Broadcast()
-	cond.L.Unlock()
-	cells := make([]util.Cell, count)
-	cells_index := 0
-	for i := 0; i != p.ImageHeight; i++ {
-		for j := 0; j != p.ImageWidth; j++ {
-			if matrix.pixels[i][j] != 0 {
-				cells[cells_index] = util.Cell{X: j, Y: i}
-				cells_index++
-			}
-		}
-	}
-	c.events <- FinalTurnComplete{turn, cells}
+	// Stop worker routines
+	sim.Close()
+	c.events <- FinalTurnComplete{turn, sim.AliveCells()}
 
-	// Write file
-	write(turn)
+	// Write file (skipped when cancelled unless requested)
+	cancelled := ctx.Err() != nil && turn < p.Turns
+	if !cancelled || p.SaveOnCancel {
+		// Keep a checkpoint if quitting before the last turn
+		snapshots.save(turn, sim.pixels(), true, turn < p.Turns)
+	}
+	snapshots.flush()
 
 	c.events <- StateChange{turn, Quitting}
 
 	// Close the channel to stop the SDL goroutine gracefully. Removing may cause deadlock.
 	close(c.events)
+	if failure == nil && cancelled {
+		return ctx.Err()
+	}
+	return failure
+}
+
+// Report a failure stopping the run before evaluation starts and close events channel
+func abort(events chan<- Event, turn int, err error) error {
+	events <- ErrorEvent{turn, err}
+	events <- StateChange{turn, Quitting}
+	close(events)
+	return err
 }
 
 func worker(wp WorkerParams) {
-	// Wait until distributor routine finishes initialisation
+	// Wait until simulator finishes initialisation
 	flipping_buffer := make([]util.Cell, 0, 1024)
 	unsafe_flipping_buffer := make([]util.Cell, 0, 64)
-	turn := 0
+	turn := wp.turn
 	wp.cond.L.Lock()
-	wp.result_chan <- TurnResult{} // notify distributor that this routine is ready
+	wp.result_chan <- TurnResult{} // notify simulator that this routine is ready
 	wp.cond.Wait()
 	wp.cond.L.Unlock()
 	// Work for each turn
@@ -234,11 +218,13 @@ func worker(wp WorkerParams) {
 		// Switch next matrix to current matrix
 		wp.matrix, wp.next_matrix = wp.next_matrix, wp.matrix
 		// Send CellsFlipped event
-		copied := make([]util.Cell, len(flipping_buffer))
-		copy(copied, flipping_buffer)
-		wp.event_chan <- CellsFlipped{turn, copied}
+		if wp.event_chan != nil {
+			copied := make([]util.Cell, len(flipping_buffer))
+			copy(copied, flipping_buffer)
+			wp.event_chan <- CellsFlipped{turn, copied}
+		}
 		turn++
-		// Send turn result to distributor
+		// Send turn result to simulator
 		wp.cond.L.Lock()
 		wp.result_chan <- TurnResult{
 			count_diff:     count_diff,
@@ -294,9 +280,9 @@ func divideToBlocks(p Params) []struct{ start, end util.Cell } {
 			}
 		}
 	}
-	// Find moderate partitioning
+	// Find moderate partitioning (blocks as square as possible)
 	i := 0
-	desired := math.Pow(float64(nthread), 0.5)
+	desired := math.Pow(float64(nthread)*float64(p.ImageHeight)/float64(p.ImageWidth), 0.5)
 	vertical := 1
 	horizontal := 1
 	for ; i != len(factors); i++ {
@@ -309,6 +295,13 @@ func divideToBlocks(p Params) []struct{ start, end util.Cell } {
 	for ; i != len(factors); i++ {
 		horizontal *= factors[len(factors)-i-1]
 	}
+	// Blocks must be at least two cells wide and high
+	if vertical > p.ImageHeight/2 {
+		vertical = int(math.Max(float64(p.ImageHeight/2), 1))
+	}
+	if horizontal > p.ImageWidth/2 {
+		horizontal = int(math.Max(float64(p.ImageWidth/2), 1))
+	}
 	// Return partitions
 	partitions := make([]struct{ start, end util.Cell }, horizontal*vertical)
 	part_width := float64(p.ImageWidth) / float64(horizontal)
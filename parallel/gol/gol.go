@@ -1,6 +1,14 @@
 package gol
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
 
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
@@ -8,21 +16,87 @@ type Params struct {
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
+	Rule        Rule     // Life-like rule (Conway's Game of Life when zero unless RuleSet)
+	RuleSet     bool     // Rule is given even when zero (B/S, without births or survivals)
+	Topology    Topology // Boundary condition at the edges of the grid
+
+	Resume             string // Checkpoint file to continue from (input image is loaded when empty)
+	CheckpointInterval int    // Write a checkpoint every given number of turns (disabled when zero)
+	SaveOnCancel       bool   // Write final image and checkpoint when the run is cancelled through its context
+
+	SnapshotInterval int           // Write an image and a checkpoint every given number of turns (disabled when zero)
+	SnapshotPeriod   time.Duration // Write an image and a checkpoint every given duration (disabled when zero)
+	SnapshotKeep     int           // Number of latest automatic snapshots whose files are kept (all when zero)
+
+	Input         string    // Input netpbm image path (images/<width>x<height>.pgm when empty)
+	Output        string    // Output path template without extension: {w}, {h}, {turn} and {time} are replaced (out/<width>x<height>x<turn> when empty)
+	Pattern       string    // RLE (.rle) or plaintext (.cells) pattern file loaded instead of input image
+	PatternOffset util.Cell // Position of the top-left corner of the pattern in the image
+	OutputFormat  string    // Format of output images: "pgm" (default), "pbm" (bit-packed), "rle" or "cells"
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
-func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+// Failures are sent as an ErrorEvent and the first of them is returned once events channel is closed.
+func Run(p Params, events chan<- Event, keyPresses <-chan rune) error {
+	return RunContext(context.Background(), p, events, keyPresses)
+}
+
+// RunContext is Run stopping at the end of current turn when ctx is cancelled or its deadline passes.
+// FinalTurnComplete and Quitting are sent as when quitting with 'q', and ctx.Err() is returned
+// unless the run failed. Final image and checkpoint are written only if p.SaveOnCancel is set.
+func RunContext(ctx context.Context, p Params, events chan<- Event, keyPresses <-chan rune) error {
+
+	// Size, rule and topology are taken from the header of checkpoint
+	if p.Resume != "" {
+		checkpoint, err := ReadCheckpoint(p.Resume)
+		if err != nil {
+			return abort(events, 0, err)
+		}
+		if err := p.resumeFrom(checkpoint.Params); err != nil {
+			return abort(events, 0, &InputError{p.Resume, err})
+		}
+	}
+
+	// Rule given in the header of pattern applies unless set
+	if !p.ruleGiven() && p.Pattern != "" && p.Resume == "" {
+		if pattern, err := ReadPattern(p.Pattern); err == nil {
+			p.Rule, p.RuleSet = pattern.Rule, pattern.RuleSet
+		}
+	}
+	p.defaultRule()
+
+	switch p.OutputFormat {
+	case "", "pgm", "pbm", "rle", "cells":
+	default:
+		return abort(events, 0, &InputError{"", fmt.Errorf("unknown output format %q", p.OutputFormat)})
+	}
+
+	if p.SnapshotInterval < 0 || p.SnapshotPeriod < 0 || p.SnapshotKeep < 0 {
+		return abort(events, 0, &InputError{"", errors.New("snapshot interval, period and number kept must not be negative")})
+	}
+
+	// Size of image is taken from the header of input image
+	if p.Input != "" && p.Resume == "" && p.Pattern == "" {
+		width, height, err := ReadImageSize(p.Input)
+		if err != nil {
+			return abort(events, 0, err)
+		}
+		p.ImageWidth, p.ImageHeight = width, height
+	}
+
+	if p.ImageWidth <= 0 || p.ImageHeight <= 0 {
+		return abort(events, 0, &InputError{"", errors.New("image size must be positive")})
+	}
 
 	io := &ioState{
 		params: p,
 		cond:   sync.NewCond(new(sync.Mutex)),
 	}
-	io.cond.L.Lock()
-	go startIo(io) // transfer ownership of lock to startIo
+	go startIo(io)
 
 	distributorChannels := distributorChannels{
 		events:     events,
 		keyPresses: keyPresses,
 	}
-	distributor(p, io, distributorChannels)
+	return distributor(ctx, p, io, distributorChannels)
 }
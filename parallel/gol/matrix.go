@@ -9,6 +9,8 @@ type Matrix struct {
 	height             int
 	pixels             [][]uint8
 	surrounding_counts [][]int8
+	rule               Rule
+	topology           Topology
 }
 
 // Make matrix object with empty data
@@ -18,10 +20,12 @@ func MakeMatrix(p Params) Matrix {
 		height:             p.ImageHeight,
 		pixels:             make([][]uint8, p.ImageHeight),
 		surrounding_counts: make([][]int8, p.ImageHeight),
+		rule:               p.Rule,
+		topology:           p.Topology,
 	}
 	pixel_data := make([]uint8, matrix.width*matrix.height)
 	count_data := make([]int8, matrix.width*matrix.height)
-	for i := 0; i != matrix.width; i++ {
+	for i := 0; i != matrix.height; i++ {
 		matrix.pixels[i] = pixel_data[0:matrix.width]
 		matrix.surrounding_counts[i] = count_data[0:matrix.width]
 		pixel_data = pixel_data[matrix.width:]
@@ -38,9 +42,11 @@ func MakeMatrixFromData(p Params, pixel_data []uint8) Matrix {
 		height:             p.ImageHeight,
 		pixels:             make([][]uint8, p.ImageHeight),
 		surrounding_counts: make([][]int8, p.ImageHeight),
+		rule:               p.Rule,
+		topology:           p.Topology,
 	}
 	count_data := make([]int8, matrix.width*matrix.height)
-	for i := 0; i != matrix.width; i++ {
+	for i := 0; i != matrix.height; i++ {
 		matrix.pixels[i] = pixel_data[0:matrix.width]
 		matrix.surrounding_counts[i] = count_data[0:matrix.width]
 		pixel_data = pixel_data[matrix.width:]
@@ -49,19 +55,11 @@ func MakeMatrixFromData(p Params, pixel_data []uint8) Matrix {
 	return *matrix
 }
 
-// Get cellition of eight surrounding cells
-func (matrix *Matrix) getSurrounding(cell util.Cell) [8]util.Cell {
+// Get positions of surrounding cells and the number of them
+// Cells at the edges of the grid may have less than eight surrounding cells depending on topology
+func (matrix *Matrix) getSurrounding(cell util.Cell) ([8]util.Cell, int) {
 	if cell.X == 0 || cell.Y == 0 || cell.X == matrix.width-1 || cell.Y == matrix.height-1 {
-		return [8]util.Cell{
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: cell.X, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: (cell.X + 1) % matrix.width, Y: (cell.Y - 1 + matrix.height) % matrix.height},
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: cell.Y},
-			{X: (cell.X + 1) % matrix.width, Y: cell.Y},
-			{X: (cell.X - 1 + matrix.width) % matrix.width, Y: (cell.Y + 1) % matrix.height},
-			{X: cell.X, Y: (cell.Y + 1) % matrix.height},
-			{X: (cell.X + 1) % matrix.width, Y: (cell.Y + 1) % matrix.height},
-		}
+		return matrix.topology.surrounding(matrix.width, matrix.height, cell)
 	} else {
 		return [8]util.Cell{
 			{X: cell.X - 1, Y: cell.Y - 1},
@@ -72,7 +70,7 @@ func (matrix *Matrix) getSurrounding(cell util.Cell) [8]util.Cell {
 			{X: cell.X - 1, Y: cell.Y + 1},
 			{X: cell.X, Y: cell.Y + 1},
 			{X: cell.X + 1, Y: cell.Y + 1},
-		}
+		}, 8
 	}
 }
 
@@ -81,10 +79,11 @@ func (matrix *Matrix) getSurrounding(cell util.Cell) [8]util.Cell {
 // Return alive cell count difference
 func (matrix *Matrix) checkAndFlip(cell util.Cell, next_matrix *Matrix, flipping_buffer *[]util.Cell) int {
 	if matrix.pixels[cell.Y][cell.X] == 0 {
-		if matrix.surrounding_counts[cell.Y][cell.X] == 3 {
+		if matrix.rule.born(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 255
-			for _, surrounding := range matrix.getSurrounding(cell) {
+			surroundings, n := matrix.getSurrounding(cell)
+			for _, surrounding := range surroundings[:n] {
 				next_matrix.surrounding_counts[surrounding.Y][surrounding.X]++
 			}
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -95,17 +94,15 @@ func (matrix *Matrix) checkAndFlip(cell util.Cell, next_matrix *Matrix, flipping
 			return 0
 		}
 	} else {
-		switch matrix.surrounding_counts[cell.Y][cell.X] {
-		case 2:
-			fallthrough
-		case 3:
+		if matrix.rule.survives(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Copying
 			next_matrix.pixels[cell.Y][cell.X] = 255
 			return 0
-		default:
+		} else {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 0
-			for _, surrounding := range matrix.getSurrounding(cell) {
+			surroundings, n := matrix.getSurrounding(cell)
+			for _, surrounding := range surroundings[:n] {
 				next_matrix.surrounding_counts[surrounding.Y][surrounding.X]--
 			}
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -118,7 +115,7 @@ func (matrix *Matrix) checkAndFlip(cell util.Cell, next_matrix *Matrix, flipping
 // Return alive cell count difference
 func (matrix *Matrix) checkAndFlipUnsafe(cell util.Cell, next_matrix *Matrix, flipping_buffer *[]util.Cell, unsafe_buffer *[]util.Cell) int {
 	if matrix.pixels[cell.Y][cell.X] == 0 {
-		if matrix.surrounding_counts[cell.Y][cell.X] == 3 {
+		if matrix.rule.born(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 255
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -130,14 +127,11 @@ func (matrix *Matrix) checkAndFlipUnsafe(cell util.Cell, next_matrix *Matrix, fl
 			return 0
 		}
 	} else {
-		switch matrix.surrounding_counts[cell.Y][cell.X] {
-		case 2:
-			fallthrough
-		case 3:
+		if matrix.rule.survives(matrix.surrounding_counts[cell.Y][cell.X]) {
 			// Copying
 			next_matrix.pixels[cell.Y][cell.X] = 255
 			return 0
-		default:
+		} else {
 			// Flipping
 			next_matrix.pixels[cell.Y][cell.X] = 0
 			*flipping_buffer = append(*flipping_buffer, cell)
@@ -0,0 +1,317 @@
+package gol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Maximum length of lines of plain (P1 and P2) netpbm files written
+const netpbmLineLength = 70
+
+// Image is a grid of cells read from or written to a netpbm file.
+type Image struct {
+	Width  int
+	Height int
+	Pixels []uint8 // Cells row by row (255 for alive cells, 0 for dead cells)
+}
+
+// Header of netpbm file
+type netpbmHeader struct {
+	magic  string
+	width  int
+	height int
+	maxval int // 1 for bitmaps
+}
+
+// ReadImage reads a netpbm image in P1, P2, P4 or P5 format.
+// Grey values above half of maxval are alive, and so are set bits of bitmaps.
+func ReadImage(path string) (Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Image{}, &IOError{path, err}
+	}
+	defer file.Close()
+
+	image, err := readNetpbm(bufio.NewReader(file))
+	if err != nil {
+		return image, &InputError{path, err}
+	}
+	return image, nil
+}
+
+// ReadImageSize reads the width and height from the header of a netpbm image.
+func ReadImageSize(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, &IOError{path, err}
+	}
+	defer file.Close()
+
+	header, err := readNetpbmHeader(bufio.NewReader(file))
+	if err != nil {
+		return 0, 0, &InputError{path, err}
+	}
+	return header.width, header.height, nil
+}
+
+// WriteImage writes an image in netpbm format "P1", "P2", "P4" or "P5" (grey values have maxval 255).
+func WriteImage(path string, image Image, format string) error {
+	if len(image.Pixels) != image.Width*image.Height {
+		return &InputError{path, errors.New("pixel data does not match image size")}
+	}
+	if format != "P1" && format != "P2" && format != "P4" && format != "P5" {
+		return &InputError{path, fmt.Errorf("unsupported netpbm format %q", format)}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return &IOError{path, err}
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	err = writeNetpbm(writer, image, format)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return &IOError{path, err}
+	}
+	return nil
+}
+
+// Read netpbm header and pixel data
+func readNetpbm(reader *bufio.Reader) (Image, error) {
+	header, err := readNetpbmHeader(reader)
+	if err != nil {
+		return Image{}, err
+	}
+	image := Image{
+		Width:  header.width,
+		Height: header.height,
+		Pixels: make([]uint8, header.width*header.height),
+	}
+	switch header.magic {
+	case "P1":
+		err = readPlainBitmap(reader, image.Pixels)
+	case "P2":
+		err = readPlainGreymap(reader, image.Pixels, header.maxval)
+	case "P4":
+		err = readBitmap(reader, image)
+	case "P5":
+		err = readGreymap(reader, image.Pixels, header.maxval)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errors.New("pixel data does not match image size")
+	}
+	return image, err
+}
+
+// Read magic number, size and maxval (the single whitespace ending the header is consumed)
+func readNetpbmHeader(reader *bufio.Reader) (netpbmHeader, error) {
+	var header netpbmHeader
+	var err error
+	header.magic, err = readNetpbmToken(reader)
+	if err != nil {
+		return header, errors.New("not a netpbm file")
+	}
+	fields := []*int{&header.width, &header.height, &header.maxval}
+	switch header.magic {
+	case "P1", "P4":
+		fields = fields[:2]
+		header.maxval = 1
+	case "P2", "P5":
+	default:
+		return header, fmt.Errorf("unsupported netpbm format %q (expected P1, P2, P4 or P5)", header.magic)
+	}
+	for _, field := range fields {
+		token, err := readNetpbmToken(reader)
+		if err != nil {
+			return header, errors.New("truncated header")
+		}
+		if *field, err = strconv.Atoi(token); err != nil {
+			return header, fmt.Errorf("invalid header value %q", token)
+		}
+	}
+	if header.width <= 0 || header.height <= 0 {
+		return header, errors.New("image size must be positive")
+	}
+	if header.maxval <= 0 || header.maxval > 65535 {
+		return header, fmt.Errorf("maxval %d out of range", header.maxval)
+	}
+	return header, nil
+}
+
+// Read next token of header or plain pixel data, skipping whitespace and comments
+// The whitespace ending the token is consumed
+func readNetpbmToken(reader *bufio.Reader) (string, error) {
+	token := make([]byte, 0, 8)
+	for {
+		char, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) != 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+		switch char {
+		case '#':
+			if _, err := reader.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+			fallthrough
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+			if len(token) != 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, char)
+		}
+	}
+}
+
+// Threshold grey value
+func alivePixel(value, maxval int) uint8 {
+	if value*2 > maxval {
+		return 255
+	}
+	return 0
+}
+
+// Read P1 pixel data (digits may be separated by whitespace or not)
+func readPlainBitmap(reader *bufio.Reader, pixels []uint8) error {
+	for i := 0; i != len(pixels); {
+		char, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch char {
+		case '0', '1':
+			pixels[i] = alivePixel(int(char-'0'), 1)
+			i++
+		case '#':
+			if _, err := reader.ReadString('\n'); err != nil {
+				return err
+			}
+		case ' ', '\t', '\n', '\r', '\v', '\f':
+		default:
+			return fmt.Errorf("unexpected %q in bitmap data", char)
+		}
+	}
+	return nil
+}
+
+// Read P2 pixel data
+func readPlainGreymap(reader *bufio.Reader, pixels []uint8, maxval int) error {
+	for i := range pixels {
+		token, err := readNetpbmToken(reader)
+		if err != nil {
+			return err
+		}
+		value, err := strconv.Atoi(token)
+		if err != nil || value < 0 || value > maxval {
+			return fmt.Errorf("invalid grey value %q", token)
+		}
+		pixels[i] = alivePixel(value, maxval)
+	}
+	return nil
+}
+
+// Read P4 pixel data (rows are padded to whole bytes, most significant bit first)
+func readBitmap(reader *bufio.Reader, image Image) error {
+	row := make([]byte, (image.Width+7)/8)
+	for y := 0; y != image.Height; y++ {
+		if _, err := io.ReadFull(reader, row); err != nil {
+			return err
+		}
+		for x := 0; x != image.Width; x++ {
+			if row[x/8]&(0x80>>(x%8)) != 0 {
+				image.Pixels[y*image.Width+x] = 255
+			}
+		}
+	}
+	return nil
+}
+
+// Read P5 pixel data (two bytes per value, most significant first, when maxval is above 255)
+func readGreymap(reader *bufio.Reader, pixels []uint8, maxval int) error {
+	size := 1
+	if maxval > 255 {
+		size = 2
+	}
+	data := make([]byte, len(pixels)*size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+	for i := range pixels {
+		value := int(data[i*size])
+		if size == 2 {
+			value = value<<8 | int(data[i*size+1])
+		}
+		pixels[i] = alivePixel(value, maxval)
+	}
+	return nil
+}
+
+// Write header and pixel data
+func writeNetpbm(writer *bufio.Writer, image Image, format string) error {
+	switch format {
+	case "P1", "P4":
+		fmt.Fprintf(writer, "%s\n%d %d\n", format, image.Width, image.Height)
+	case "P2", "P5":
+		fmt.Fprintf(writer, "%s\n%d %d\n255\n", format, image.Width, image.Height)
+	default:
+		return fmt.Errorf("unsupported netpbm format %q", format)
+	}
+
+	switch format {
+	case "P4":
+		row := make([]byte, (image.Width+7)/8)
+		for y := 0; y != image.Height; y++ {
+			for i := range row {
+				row[i] = 0
+			}
+			packBits(row, image.Pixels[y*image.Width:(y+1)*image.Width], true)
+			writer.Write(row)
+		}
+	case "P5":
+		for _, pixel := range image.Pixels {
+			if pixel != 0 {
+				pixel = 255
+			}
+			writer.WriteByte(pixel)
+		}
+	default:
+		// Plain formats with values separated by spaces and lines no longer than netpbmLineLength
+		alive, dead := "1", "0"
+		if format == "P2" {
+			alive, dead = "255", "0"
+		}
+		for y := 0; y != image.Height; y++ {
+			line_length := 0
+			for x := 0; x != image.Width; x++ {
+				value := dead
+				if image.Pixels[y*image.Width+x] != 0 {
+					value = alive
+				}
+				if line_length != 0 && line_length+1+len(value) > netpbmLineLength {
+					writer.WriteByte('\n')
+					line_length = 0
+				} else if line_length != 0 {
+					writer.WriteByte(' ')
+					line_length++
+				}
+				writer.WriteString(value)
+				line_length += len(value)
+			}
+			writer.WriteByte('\n')
+		}
+	}
+	return nil
+}
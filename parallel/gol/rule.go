@@ -0,0 +1,98 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule describes a life-like cellular automaton in B/S notation.
+// Bit n of Birth is set if a dead cell with n alive neighbours becomes alive,
+// bit n of Survive is set if an alive cell with n alive neighbours stays alive.
+// A zero Rule in Params or Pattern is treated as Conway's Game of Life unless RuleSet is set.
+type Rule struct {
+	Birth   uint16
+	Survive uint16
+}
+
+// Conway's Game of Life (B3/S23)
+var Conway = Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3}
+
+// Check if rule of p was given (B/S, the zero Rule, is only given with RuleSet)
+func (p *Params) ruleGiven() bool {
+	return p.RuleSet || p.Rule != (Rule{})
+}
+
+// Replace rule of p with Conway's Game of Life unless given
+func (p *Params) defaultRule() {
+	if !p.ruleGiven() {
+		p.Rule = Conway
+	}
+	p.RuleSet = true
+}
+
+// Parse rule string in B/S notation such as "B36/S23" (HighLife) or "B2/S" (Seeds)
+func ParseRule(rule string) (Rule, error) {
+	var result Rule
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(rule)), "/")
+	if len(parts) != 2 {
+		return result, fmt.Errorf("invalid rule %q: expected B/S notation such as B3/S23", rule)
+	}
+	has_birth, has_survive := false, false
+	for _, part := range parts {
+		if part == "" {
+			return result, fmt.Errorf("invalid rule %q: empty part", rule)
+		}
+		var mask *uint16
+		switch part[0] {
+		case 'B':
+			mask = &result.Birth
+			if has_birth {
+				return result, fmt.Errorf("invalid rule %q: duplicated B part", rule)
+			}
+			has_birth = true
+		case 'S':
+			mask = &result.Survive
+			if has_survive {
+				return result, fmt.Errorf("invalid rule %q: duplicated S part", rule)
+			}
+			has_survive = true
+		default:
+			return result, fmt.Errorf("invalid rule %q: unknown part %q", rule, part)
+		}
+		for _, digit := range part[1:] {
+			if digit < '0' || digit > '8' {
+				return result, fmt.Errorf("invalid rule %q: neighbour count %q out of range", rule, digit)
+			}
+			*mask |= 1 << (digit - '0')
+		}
+	}
+	return result, nil
+}
+
+// String formats rule in B/S notation
+func (rule Rule) String() string {
+	var builder strings.Builder
+	builder.WriteByte('B')
+	for count := 0; count <= 8; count++ {
+		if rule.Birth&(1<<count) != 0 {
+			builder.WriteByte(byte('0' + count))
+		}
+	}
+	builder.WriteString("/S")
+	for count := 0; count <= 8; count++ {
+		if rule.Survive&(1<<count) != 0 {
+			builder.WriteByte(byte('0' + count))
+		}
+	}
+	return builder.String()
+}
+
+// Check if a dead cell with given number of alive neighbours becomes alive
+func (rule *Rule) born(count int8) bool {
+	return rule.Birth&(1<<uint8(count)) != 0
+}
+
+// Check if an alive cell with given number of alive neighbours stays alive
+func (rule *Rule) survives(count int8) bool {
+	return rule.Survive&(1<<uint8(count)) != 0
+}
@@ -0,0 +1,292 @@
+package gol
+
+import (
+	"errors"
+	"sync"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Simulator evaluates Game of Life on worker goroutines and can be driven directly by other Go code.
+// Methods may be called from any goroutine. Calls other than Step, Pause and Resume wait for
+// the turn being evaluated, so they always see the state at the end of a turn.
+type Simulator struct {
+	p           Params
+	mutex       *sync.Mutex // Held while evaluating a turn
+	state_cond  *sync.Cond  // Signalled when paused, resumed or closed
+	matrix      Matrix
+	next_matrix Matrix
+	turn        int
+	count       int
+	paused      bool
+	closed      bool
+
+	nthread       int
+	running_flag  bool       // Exit all routines when set to false
+	cond          *sync.Cond // Condition variable for worker routines wait for simulator collecting results
+	result_chan   chan TurnResult
+	result_buffer []TurnResult
+	events        chan<- Event // CellsFlipped sent by worker routines (nil when not sent)
+}
+
+// NewSimulator creates a simulator of a world of p.ImageHeight rows of p.ImageWidth cells (non-zero when alive).
+// Worker goroutines are started, and they are stopped by Close.
+func NewSimulator(p Params, world [][]uint8) (*Simulator, error) {
+	p.defaultRule()
+	if p.ImageWidth <= 0 || p.ImageHeight <= 0 || len(world) != p.ImageHeight {
+		return nil, &InputError{"", errors.New("world does not match image size")}
+	}
+	pixels := make([]uint8, 0, p.ImageWidth*p.ImageHeight)
+	for _, row := range world {
+		if len(row) != p.ImageWidth {
+			return nil, &InputError{"", errors.New("world does not match image size")}
+		}
+		for _, pixel := range row {
+			if pixel != 0 {
+				pixel = 255
+			}
+			pixels = append(pixels, pixel)
+		}
+	}
+	return newSimulator(p, pixels, 0, nil), nil
+}
+
+// Create simulator taking ownership of pixel data at given turn, sending CellsFlipped events if channel is not nil
+func newSimulator(p Params, pixels []uint8, turn int, events chan<- Event) *Simulator {
+	sim := &Simulator{
+		p:           p,
+		mutex:       new(sync.Mutex),
+		matrix:      MakeMatrixFromData(p, pixels),
+		next_matrix: MakeMatrix(p),
+		turn:        turn,
+		cond:        sync.NewCond(new(sync.Mutex)),
+		result_chan: make(chan TurnResult),
+		events:      events,
+	}
+	sim.state_cond = sync.NewCond(sim.mutex)
+
+	// Count alive cells and their surrounding counts
+	for i := 0; i != p.ImageHeight; i++ {
+		for j := 0; j != p.ImageWidth; j++ {
+			if sim.matrix.pixels[i][j] != 0 {
+				sim.count++
+				sim.addSurrounding(util.Cell{X: j, Y: i}, 1)
+			}
+		}
+	}
+
+	// Create goroutines
+	blocks := divideToBlocks(p)
+	sim.nthread = len(blocks)
+	sim.running_flag = true
+	sim.result_buffer = make([]TurnResult, sim.nthread)
+	for i := 0; i != sim.nthread; i++ {
+		wp := WorkerParams{
+			p:           p,
+			matrix:      sim.matrix,
+			next_matrix: sim.next_matrix,
+			start:       blocks[i].start,
+			end:         blocks[i].end,
+			turn:        turn,
+			running:     &sim.running_flag,
+			cond:        sim.cond,
+			result_chan: sim.result_chan,
+			event_chan:  events,
+		}
+		go worker(wp)
+		<-sim.result_chan // Make sure goroutine is ready
+	}
+	return sim
+}
+
+// Step evaluates n turns and returns the number of turns evaluated.
+// Step waits while the simulator is paused, and returns early if it is closed.
+func (sim *Simulator) Step(n int) int {
+	evaluated := 0
+	for ; evaluated < n; evaluated++ {
+		sim.mutex.Lock()
+		for sim.paused && !sim.closed {
+			sim.state_cond.Wait()
+		}
+		if sim.closed {
+			sim.mutex.Unlock()
+			break
+		}
+		sim.next()
+		sim.mutex.Unlock()
+	}
+	return evaluated
+}
+
+// Evaluate one turn on worker routines (mutex must be held)
+func (sim *Simulator) next() {
+	// Broadcast as critical section to prevent any routine not in waiting state before broadcast
+	sim.cond.L.Lock()
+	sim.cond.Broadcast()
+	sim.cond.L.Unlock()
+	// Get results for current turn
+	for thread_index := 0; thread_index != sim.nthread; thread_index++ {
+		sim.result_buffer[thread_index] = <-sim.result_chan
+	}
+	// All routines completed current turn
+	for thread_index := 0; thread_index != sim.nthread; thread_index++ {
+		sim.count += sim.result_buffer[thread_index].count_diff
+		for _, cell := range sim.result_buffer[thread_index].unsafe_flipped {
+			surroundings, n := sim.matrix.getSurrounding(cell)
+			if sim.matrix.pixels[cell.Y][cell.X] == 0 {
+				for _, surrounding := range surroundings[:n] {
+					sim.next_matrix.surrounding_counts[surrounding.Y][surrounding.X]++
+				}
+			} else {
+				for _, surrounding := range surroundings[:n] {
+					sim.next_matrix.surrounding_counts[surrounding.Y][surrounding.X]--
+				}
+			}
+		}
+	}
+	// Swap current and next matrix
+	sim.matrix, sim.next_matrix = sim.next_matrix, sim.matrix
+	sim.turn++
+}
+
+// Pause makes Step wait at the end of current turn until Resume is called.
+// It fails if the simulator is closed.
+func (sim *Simulator) Pause() error {
+	return sim.setPaused(true)
+}
+
+// Resume continues a paused Step. It fails if the simulator is closed.
+func (sim *Simulator) Resume() error {
+	return sim.setPaused(false)
+}
+
+func (sim *Simulator) setPaused(paused bool) error {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	if sim.closed {
+		return errors.New("simulator closed")
+	}
+	sim.paused = paused
+	sim.state_cond.Broadcast()
+	return nil
+}
+
+// Paused reports whether the simulator is paused.
+func (sim *Simulator) Paused() bool {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.paused
+}
+
+// Turn returns the number of completed turns.
+func (sim *Simulator) Turn() int {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.turn
+}
+
+// AliveCount returns the number of alive cells.
+func (sim *Simulator) AliveCount() int {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.count
+}
+
+// AliveCells returns the positions of alive cells row by row.
+func (sim *Simulator) AliveCells() []util.Cell {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	cells := make([]util.Cell, 0, sim.count)
+	for i := 0; i != sim.p.ImageHeight; i++ {
+		for j := 0; j != sim.p.ImageWidth; j++ {
+			if sim.matrix.pixels[i][j] != 0 {
+				cells = append(cells, util.Cell{X: j, Y: i})
+			}
+		}
+	}
+	return cells
+}
+
+// Snapshot returns a copy of the world (255 for alive cells, 0 for dead cells).
+func (sim *Simulator) Snapshot() [][]uint8 {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	world := make([][]uint8, sim.p.ImageHeight)
+	for y := range world {
+		world[y] = append([]uint8(nil), sim.matrix.pixels[y]...)
+	}
+	return world
+}
+
+// SetCell sets the state of the cell at (x, y).
+func (sim *Simulator) SetCell(x, y int, alive bool) error {
+	return sim.setRegion(util.Cell{X: x, Y: y}, util.Cell{X: x + 1, Y: y + 1}, alive)
+}
+
+// ClearRegion kills all cells from start to end (not inclusive).
+func (sim *Simulator) ClearRegion(start, end util.Cell) error {
+	return sim.setRegion(start, end, false)
+}
+
+// Set all cells of a region to the same state (flipped cells are sent as CellsFlipped)
+func (sim *Simulator) setRegion(start, end util.Cell, alive bool) error {
+	if start.X < 0 || start.Y < 0 || end.X > sim.p.ImageWidth || end.Y > sim.p.ImageHeight ||
+		start.X > end.X || start.Y > end.Y {
+		return &InputError{"", errors.New("region outside of world")}
+	}
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	flipped := make([]util.Cell, 0, 64)
+	for y := start.Y; y != end.Y; y++ {
+		for x := start.X; x != end.X; x++ {
+			if (sim.matrix.pixels[y][x] != 0) == alive {
+				continue
+			}
+			cell := util.Cell{X: x, Y: y}
+			if alive {
+				sim.matrix.pixels[y][x] = 255
+				sim.count++
+				sim.addSurrounding(cell, 1)
+			} else {
+				sim.matrix.pixels[y][x] = 0
+				sim.count--
+				sim.addSurrounding(cell, -1)
+			}
+			flipped = append(flipped, cell)
+		}
+	}
+	if sim.events != nil && len(flipped) != 0 {
+		sim.events <- CellsFlipped{sim.turn, flipped}
+	}
+	return nil
+}
+
+// Add to surrounding counts of cells surrounding given cell
+func (sim *Simulator) addSurrounding(cell util.Cell, diff int8) {
+	surroundings, n := sim.matrix.getSurrounding(cell)
+	for _, surrounding := range surroundings[:n] {
+		sim.matrix.surrounding_counts[surrounding.Y][surrounding.X] += diff
+	}
+}
+
+// Pixels of current matrix row by row (shared with simulator, so it must not be evaluating)
+func (sim *Simulator) pixels() []uint8 {
+	return sim.matrix.pixels[0][0 : sim.p.ImageWidth*sim.p.ImageHeight]
+}
+
+// Close stops worker goroutines. A Step waiting while paused returns.
+func (sim *Simulator) Close() {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	if sim.closed {
+		return
+	}
+	sim.closed = true
+	sim.state_cond.Broadcast()
+
+	// Set flag variable to exit all worker routines
+	sim.cond.L.Lock()
+	sim.running_flag = false
+	sim.cond.Broadcast()
+	sim.cond.L.Unlock()
+}
@@ -0,0 +1,162 @@
+package gol
+
+import (
+	"os"
+	"time"
+)
+
+// Maximum number of snapshots being written at the same time (taking another one waits for the oldest)
+// Two buffers let evaluation fill one while the other is written
+const maxPendingSnapshots = 2
+
+// snapshotWriter writes copies of the world in the background so that evaluation continues meanwhile.
+// Events of written snapshots are sent by the distributor in the order snapshots were taken.
+type snapshotWriter struct {
+	io      *ioState
+	events  chan<- Event
+	report  func(turn int, err error) // Called for failures of writing files
+	started time.Time                 // Time the run started, shared by names of output files
+	pending []*snapshot               // Snapshots being written, oldest first
+	free    [][]uint8                 // Buffers of written snapshots reused by later ones
+
+	keep      int        // Number of automatic snapshots whose files are kept (all when zero)
+	retained  [][]string // Paths of files of automatic snapshots kept, oldest first
+	autosaved bool       // Automatic snapshot taken
+	auto_turn int        // Turn of last automatic snapshot
+}
+
+// Copy of the world at a turn and the operations writing it
+type snapshot struct {
+	turn       int
+	pixels     []uint8
+	operations []*ioOperation
+	auto       bool // Files are removed when more than keep automatic snapshots are written
+}
+
+// Copy pixels and request writing them as an image and/or a checkpoint without waiting
+func (w *snapshotWriter) save(turn int, pixels []uint8, image, checkpoint bool) {
+	if !image && !checkpoint {
+		return
+	}
+	for len(w.pending) >= maxPendingSnapshots {
+		w.complete()
+	}
+	var buffer []uint8
+	if n := len(w.free); n != 0 {
+		buffer, w.free = w.free[n-1], w.free[:n-1]
+	} else {
+		buffer = make([]uint8, len(pixels))
+	}
+	copy(buffer, pixels)
+
+	s := &snapshot{turn: turn, pixels: buffer}
+	filename := outputName(w.io.params, turn, w.started)
+	if image {
+		s.operations = append(s.operations, &ioOperation{
+			command:  ioOutput,
+			filename: filename,
+			data:     buffer,
+		})
+	}
+	if checkpoint {
+		s.operations = append(s.operations, &ioOperation{
+			command:  ioCheckpointOutput,
+			filename: filename,
+			data:     buffer,
+			turn:     turn,
+		})
+	}
+	for _, operation := range s.operations {
+		w.io.sendIoRequest(operation)
+	}
+	w.pending = append(w.pending, s)
+}
+
+// Take an automatic snapshot of an image and a checkpoint (skipped when the turn did not change since the last one)
+func (w *snapshotWriter) autosave(turn int, pixels []uint8) {
+	if w.autosaved && turn == w.auto_turn {
+		return
+	}
+	w.autosaved, w.auto_turn = true, turn
+	w.save(turn, pixels, true, true)
+	w.pending[len(w.pending)-1].auto = true
+}
+
+// Send events of snapshots already written without waiting
+func (w *snapshotWriter) poll() {
+	for len(w.pending) != 0 && w.written(w.pending[0]) {
+		w.complete()
+	}
+}
+
+// Wait for all snapshots being written and send their events
+func (w *snapshotWriter) flush() {
+	for len(w.pending) != 0 {
+		w.complete()
+	}
+}
+
+// Check whether all operations of snapshot completed
+func (w *snapshotWriter) written(s *snapshot) bool {
+	for _, operation := range s.operations {
+		if !w.io.completedIoRequest(operation) {
+			return false
+		}
+	}
+	return true
+}
+
+// Wait for oldest snapshot, send its events and keep its buffer for reuse
+func (w *snapshotWriter) complete() {
+	s := w.pending[0]
+	w.pending = w.pending[1:]
+	paths := make([]string, 0, len(s.operations))
+	for _, operation := range s.operations {
+		if err := w.io.waitIoRequest(operation); err != nil {
+			w.report(s.turn, err)
+			continue
+		}
+		paths = append(paths, operation.path)
+		if operation.command == ioOutput {
+			w.events <- ImageOutputComplete{s.turn, operation.filename}
+		} else {
+			w.events <- CheckpointComplete{s.turn, operation.filename}
+		}
+	}
+	w.free = append(w.free, s.pixels)
+	w.retain(paths, s.auto)
+}
+
+// Keep paths of files written by a snapshot and remove files of the oldest automatic snapshots beyond keep
+// Files also written by a later snapshot (same name or requested by the user) are not removed
+func (w *snapshotWriter) retain(paths []string, auto bool) {
+	for _, retained := range w.retained {
+		for i, path := range retained {
+			if contains(paths, path) {
+				retained[i] = ""
+			}
+		}
+	}
+	if !auto {
+		return
+	}
+	w.retained = append(w.retained, paths)
+	for w.keep > 0 && len(w.retained) > w.keep {
+		for _, path := range w.retained[0] {
+			if path != "" {
+				_ = os.Remove(path)
+			}
+		}
+		w.retained = w.retained[1:]
+	}
+}
+
+// Check whether path is one of paths
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
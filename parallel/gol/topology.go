@@ -0,0 +1,112 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Topology describes how the edges of the grid are connected.
+type Topology uint8
+
+const (
+	Torus       Topology = iota // Both pairs of edges wrap around (default)
+	DeadBorder                  // Cells outside the grid are permanently dead
+	Reflective                  // Edges are mirrored, so cells just outside the grid copy the edge cells
+	KleinBottle                 // Left and right edges wrap around, top and bottom edges wrap around flipped
+	Cylinder                    // Left and right edges wrap around, top and bottom edges are dead borders
+)
+
+// Relative positions of eight surrounding cells
+var surroundingOffsets = [8]util.Cell{
+	{X: -1, Y: -1}, {X: 0, Y: -1}, {X: 1, Y: -1},
+	{X: -1, Y: 0}, {X: 1, Y: 0},
+	{X: -1, Y: 1}, {X: 0, Y: 1}, {X: 1, Y: 1},
+}
+
+// Parse topology name (torus, dead, reflective, klein or cylinder)
+func ParseTopology(name string) (Topology, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "torus":
+		return Torus, nil
+	case "dead", "deadborder":
+		return DeadBorder, nil
+	case "reflective", "mirror":
+		return Reflective, nil
+	case "klein", "kleinbottle":
+		return KleinBottle, nil
+	case "cylinder":
+		return Cylinder, nil
+	default:
+		return Torus, fmt.Errorf("unknown topology %q: expected torus, dead, reflective, klein or cylinder", name)
+	}
+}
+
+func (topology Topology) String() string {
+	switch topology {
+	case Torus:
+		return "torus"
+	case DeadBorder:
+		return "dead"
+	case Reflective:
+		return "reflective"
+	case KleinBottle:
+		return "klein"
+	case Cylinder:
+		return "cylinder"
+	default:
+		return "Incorrect Topology"
+	}
+}
+
+// Map a position that may lie outside the grid to the cell it refers to
+// Return false if the position is a permanently dead cell
+func (topology Topology) resolve(width, height int, x, y int) (util.Cell, bool) {
+	switch topology {
+	case DeadBorder:
+		if x < 0 || x >= width || y < 0 || y >= height {
+			return util.Cell{}, false
+		}
+	case Reflective:
+		if x < 0 {
+			x = -1 - x
+		} else if x >= width {
+			x = 2*width - 1 - x
+		}
+		if y < 0 {
+			y = -1 - y
+		} else if y >= height {
+			y = 2*height - 1 - y
+		}
+	case KleinBottle:
+		if y < 0 || y >= height {
+			x = width - 1 - x
+			y = (y + height) % height
+		}
+		x = (x + width) % width
+	case Cylinder:
+		if y < 0 || y >= height {
+			return util.Cell{}, false
+		}
+		x = (x + width) % width
+	default:
+		x = (x + width) % width
+		y = (y + height) % height
+	}
+	return util.Cell{X: x, Y: y}, true
+}
+
+// Get positions of surrounding cells of a cell at the edges of the grid
+// Return the number of valid positions (less than eight if some surrounding cells are permanently dead)
+func (topology Topology) surrounding(width, height int, cell util.Cell) ([8]util.Cell, int) {
+	var result [8]util.Cell
+	count := 0
+	for _, offset := range surroundingOffsets {
+		if surrounding, ok := topology.resolve(width, height, cell.X+offset.X, cell.Y+offset.Y); ok {
+			result[count] = surrounding
+			count++
+		}
+	}
+	return result, count
+}
@@ -42,3 +42,38 @@ func TestGol(t *testing.T) {
 		}
 	}
 }
+
+// TestGolRectangular tests 64x16, 16x64 and 200x100 images on 0, 1 and 100 turns using 1-16 worker threads.
+func TestGolRectangular(t *testing.T) {
+	tests := []gol.Params{
+		{ImageWidth: 64, ImageHeight: 16},
+		{ImageWidth: 16, ImageHeight: 64},
+		{ImageWidth: 200, ImageHeight: 100},
+	}
+	for _, p := range tests {
+		for _, turns := range []int{0, 1, 100} {
+			p.Turns = turns
+			expectedAlive := readAliveCells(
+				"check/images/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
+				p.ImageWidth,
+				p.ImageHeight,
+			)
+			for threads := 1; threads <= 16; threads++ {
+				p.Threads = threads
+				testName := fmt.Sprintf("%dx%dx%d-%d", p.ImageWidth, p.ImageHeight, p.Turns, p.Threads)
+				t.Run(testName, func(t *testing.T) {
+					events := make(chan gol.Event)
+					go gol.Run(p, events, nil)
+					var cells []util.Cell
+					for event := range events {
+						switch e := event.(type) {
+						case gol.FinalTurnComplete:
+							cells = e.Alive
+						}
+					}
+					assertEqualBoard(t, cells, expectedAlive, p)
+				})
+			}
+		}
+	}
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"image"
 	"runtime"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/record"
 	"uk.ac.bris.cs/gameoflife/sdl"
 )
 
@@ -41,6 +44,100 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	rule := flag.String(
+		"rule",
+		"B3/S23",
+		"Specify the life-like rule in B/S notation, e.g. B36/S23 for HighLife. Defaults to B3/S23.")
+
+	topology := flag.String(
+		"topology",
+		"torus",
+		"Specify the boundary condition: torus, dead, reflective, klein or cylinder. Defaults to torus.")
+
+	flag.StringVar(
+		&params.Resume,
+		"resume",
+		"",
+		"Specify a checkpoint file to resume from. Size, rule and topology are taken from the checkpoint.")
+
+	flag.StringVar(
+		&params.Input,
+		"in",
+		"",
+		"Specify the input netpbm image (P1, P2, P4 or P5). Size is taken from its header. Defaults to images/<w>x<h>.pgm.")
+
+	flag.StringVar(
+		&params.Output,
+		"out",
+		"",
+		"Specify the output path without extension, where {w}, {h}, {turn} and {time} are replaced. Defaults to out/<w>x<h>x<turn>.")
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify an RLE (.rle) or plaintext (.cells) pattern file to load instead of the input image.")
+
+	offset := flag.String(
+		"offset",
+		"0,0",
+		"Specify the position x,y of the top-left corner of the pattern in the image. Defaults to 0,0.")
+
+	flag.StringVar(
+		&params.OutputFormat,
+		"format",
+		"pgm",
+		"Specify the format of output images: pgm, pbm (bit-packed), rle or cells. Defaults to pgm.")
+
+	flag.IntVar(
+		&params.CheckpointInterval,
+		"checkpoint",
+		0,
+		"Specify the number of turns between checkpoints. Defaults to 0 (only on 's' and early quit).")
+
+	flag.IntVar(
+		&params.SnapshotInterval,
+		"snapshot",
+		0,
+		"Specify the number of turns between automatic snapshots (image and checkpoint). Defaults to 0 (disabled).")
+
+	flag.DurationVar(
+		&params.SnapshotPeriod,
+		"snapshot-every",
+		0,
+		"Specify the time between automatic snapshots, e.g. 30s. Defaults to 0 (disabled).")
+
+	flag.IntVar(
+		&params.SnapshotKeep,
+		"snapshot-keep",
+		0,
+		"Specify the number of latest automatic snapshots kept. Defaults to 0 (all kept).")
+
+	recordPath := flag.String(
+		"record",
+		"",
+		"Specify an animated GIF (.gif) or numbered PNG sequence (.png) to record the run to.")
+
+	recordStride := flag.Int(
+		"record-stride",
+		1,
+		"Specify the number of turns between recorded frames. Defaults to 1.")
+
+	recordScale := flag.Int(
+		"record-scale",
+		1,
+		"Specify the number of pixels per side of a recorded cell. Defaults to 1.")
+
+	recordRegion := flag.String(
+		"record-region",
+		"",
+		"Specify the recorded region x,y,w,h of the image. Defaults to the whole image.")
+
+	timeout := flag.Duration(
+		"timeout",
+		0,
+		"Specify the maximum duration of the run, e.g. 10m. Defaults to 0 (no limit).")
+
 	headless := flag.Bool(
 		"headless",
 		false,
@@ -48,27 +145,120 @@ func main() {
 
 	flag.Parse()
 
+	var err error
+	params.Rule, err = gol.ParseRule(*rule)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	params.RuleSet = true
+	params.Topology, err = gol.ParseTopology(*topology)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if _, err := fmt.Sscanf(*offset, "%d,%d", &params.PatternOffset.X, &params.PatternOffset.Y); err != nil {
+		fmt.Println("invalid offset", *offset+": expected x,y")
+		os.Exit(2)
+	}
+
+	if params.Input != "" && params.Resume == "" && params.Pattern == "" {
+		params.ImageWidth, params.ImageHeight, err = gol.ReadImageSize(params.Input)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		fmt.Printf("%-10v %v\n", "Input", params.Input)
+	}
+
+	if params.Pattern != "" && params.Resume == "" {
+		pattern, err := gol.ReadPattern(params.Pattern)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		rule_set := false
+		flag.Visit(func(f *flag.Flag) { rule_set = rule_set || f.Name == "rule" })
+		if !rule_set && pattern.RuleSet {
+			params.Rule = pattern.Rule
+		}
+		fmt.Printf("%-10v %v (%vx%v at %v,%v)\n", "Pattern", params.Pattern,
+			pattern.Width, pattern.Height, params.PatternOffset.X, params.PatternOffset.Y)
+	}
+
+	if params.Resume != "" {
+		checkpoint, err := gol.ReadCheckpoint(params.Resume)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		params.ImageWidth = checkpoint.Params.ImageWidth
+		params.ImageHeight = checkpoint.Params.ImageHeight
+		params.Rule, params.RuleSet = checkpoint.Params.Rule, checkpoint.Params.RuleSet
+		params.Topology = checkpoint.Params.Topology
+		fmt.Printf("%-10v %v (turn %v)\n", "Resume", params.Resume, checkpoint.Turn)
+	}
+
+	if params.Output != "" {
+		fmt.Printf("%-10v %v\n", "Output", params.Output)
+	}
 	fmt.Printf("%-10v %v\n", "Threads", params.Threads)
 	fmt.Printf("%-10v %v\n", "Width", params.ImageWidth)
 	fmt.Printf("%-10v %v\n", "Height", params.ImageHeight)
 	fmt.Printf("%-10v %v\n", "Turns", params.Turns)
+	fmt.Printf("%-10v %v\n", "Rule", params.Rule)
+	fmt.Printf("%-10v %v\n", "Topology", params.Topology)
+
+	var recorder *record.Recorder
+	if *recordPath != "" {
+		options := record.Options{Path: *recordPath, Stride: *recordStride, Scale: *recordScale}
+		if *recordRegion != "" {
+			var x, y, w, h int
+			if _, err := fmt.Sscanf(*recordRegion, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil {
+				fmt.Println("invalid record region", *recordRegion+": expected x,y,w,h")
+				os.Exit(2)
+			}
+			options.Region = image.Rect(x, y, x+w, y+h)
+		}
+		recorder, err = record.NewRecorder(params, options)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		fmt.Printf("%-10v %v\n", "Record", *recordPath)
+	}
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
 
-	go sigterm(keyPresses)
+	// Stop at the end of current turn on SIGTERM or SIGINT, or when timeout passes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	params.SaveOnCancel = true
 
-	go gol.Run(params, events, keyPresses)
+	go gol.RunContext(ctx, params, events, keyPresses)
+	var display <-chan gol.Event = events
+	if recorder != nil {
+		display = recorder.Tee(events)
+	}
 	if !(*headless) {
-		sdl.Run(params, events, keyPresses)
+		sdl.Run(params, display, keyPresses)
 	} else {
-		sdl.RunHeadless(events)
+		sdl.RunHeadless(display)
 	}
-}
 
-func sigterm(keyPresses chan<- rune) {
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGTERM, syscall.SIGINT)
-	<-sigterm
-	keyPresses <- 'q'
+	// Write animation after the run quits
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%-10v %v (%v frames)\n", "Recorded", *recordPath, recorder.Frames())
+	}
 }
@@ -0,0 +1,203 @@
+package record
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// Colours of frames (alive cells are white as in the SDL window)
+var palette = color.Palette{color.Black, color.White}
+
+// Options configures what a Recorder captures and where it is written.
+type Options struct {
+	Path   string          // Animated GIF (.gif), or PNG sequence numbered by frame (<name>-000000.png for .png)
+	Stride int             // Turns between frames (1 when zero)
+	Scale  int             // Pixels per side of a cell (1 when zero)
+	Region image.Rectangle // Cells captured (whole image when empty)
+	Delay  int             // Delay between GIF frames in 100ths of a second (5 when zero)
+}
+
+// Recorder builds an animation of a run from its events.
+// Frames of a GIF are kept in memory until Close, so large regions should use a stride or a PNG sequence.
+type Recorder struct {
+	options Options
+	width   int
+	world   []bool // Cells row by row, updated by CellsFlipped events
+	started bool   // First event received
+	turn    int    // Turns completed, counted from TurnComplete events
+	last    int    // Turn of last frame captured (-1 before first frame)
+	frames  int    // Number of frames captured
+	gif     gif.GIF
+	err     error         // First failure of writing frames
+	done    chan struct{} // Closed when events passed to Tee are drained (nil when Tee is not used)
+}
+
+// NewRecorder creates a recorder of a run with parameters p.
+func NewRecorder(p gol.Params, options Options) (*Recorder, error) {
+	bounds := image.Rect(0, 0, p.ImageWidth, p.ImageHeight)
+	if options.Region.Empty() {
+		options.Region = bounds
+	}
+	if !options.Region.In(bounds) {
+		return nil, &gol.InputError{Err: fmt.Errorf("region %v outside of %dx%d image", options.Region, p.ImageWidth, p.ImageHeight)}
+	}
+	if options.Stride <= 0 {
+		options.Stride = 1
+	}
+	if options.Scale <= 0 {
+		options.Scale = 1
+	}
+	if options.Delay <= 0 {
+		options.Delay = 5
+	}
+	switch strings.ToLower(filepath.Ext(options.Path)) {
+	case ".gif", ".png":
+	default:
+		return nil, &gol.InputError{Path: options.Path, Err: errors.New("unknown animation format (expected .gif or .png)")}
+	}
+	if err := os.MkdirAll(filepath.Dir(options.Path), os.ModePerm); err != nil {
+		return nil, &gol.IOError{Path: options.Path, Err: err}
+	}
+	return &Recorder{
+		options: options,
+		width:   p.ImageWidth,
+		world:   make([]bool, p.ImageWidth*p.ImageHeight),
+		last:    -1,
+	}, nil
+}
+
+// Record applies an event to the world of the recorder, capturing a frame every Stride turns.
+// The first frame is captured when execution starts, and the last one when the final turn completes.
+func (r *Recorder) Record(event gol.Event) {
+	if !r.started {
+		r.started = true
+		r.turn = event.GetCompletedTurns()
+	}
+	switch e := event.(type) {
+	case gol.CellFlipped:
+		r.flip(e.Cell.X, e.Cell.Y)
+	case gol.CellsFlipped:
+		for _, cell := range e.Cells {
+			r.flip(cell.X, cell.Y)
+		}
+	case gol.StateChange:
+		if e.NewState == gol.Executing && r.last < 0 {
+			r.capture()
+		}
+	case gol.TurnComplete:
+		r.turn++
+		if r.turn%r.options.Stride == 0 {
+			r.capture()
+		}
+	case gol.FinalTurnComplete:
+		for i := range r.world {
+			r.world[i] = false
+		}
+		for _, cell := range e.Alive {
+			r.world[cell.Y*r.width+cell.X] = true
+		}
+		if e.CompletedTurns != r.last {
+			r.turn = e.CompletedTurns
+			r.capture()
+		}
+	}
+}
+
+func (r *Recorder) flip(x, y int) {
+	r.world[y*r.width+x] = !r.world[y*r.width+x]
+}
+
+// Tee records events while passing them on to the returned channel, which is closed when events is closed.
+func (r *Recorder) Tee(events <-chan gol.Event) <-chan gol.Event {
+	forwarded := make(chan gol.Event, cap(events))
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		defer close(forwarded)
+		for event := range events {
+			r.Record(event)
+			forwarded <- event
+		}
+	}()
+	return forwarded
+}
+
+// Capture region of current world as a frame
+func (r *Recorder) capture() {
+	r.last = r.turn
+	region, scale := r.options.Region, r.options.Scale
+	frame := image.NewPaletted(image.Rect(0, 0, region.Dx()*scale, region.Dy()*scale), palette)
+	for y := region.Min.Y; y != region.Max.Y; y++ {
+		for x := region.Min.X; x != region.Max.X; x++ {
+			if !r.world[y*r.width+x] {
+				continue
+			}
+			for i := 0; i != scale; i++ {
+				row := frame.Pix[((y-region.Min.Y)*scale+i)*frame.Stride:]
+				for j := 0; j != scale; j++ {
+					row[(x-region.Min.X)*scale+j] = 1
+				}
+			}
+		}
+	}
+
+	if strings.ToLower(filepath.Ext(r.options.Path)) == ".gif" {
+		r.gif.Image = append(r.gif.Image, frame)
+		r.gif.Delay = append(r.gif.Delay, r.options.Delay)
+	} else if r.err == nil {
+		r.err = writePNG(r.framePath(r.frames), frame)
+	}
+	r.frames++
+}
+
+// Path of numbered PNG frame
+func (r *Recorder) framePath(index int) string {
+	extension := filepath.Ext(r.options.Path)
+	return fmt.Sprintf("%s-%06d%s", strings.TrimSuffix(r.options.Path, extension), index, extension)
+}
+
+func writePNG(path string, frame image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return &gol.IOError{Path: path, Err: err}
+	}
+	defer file.Close()
+	if err = png.Encode(file, frame); err != nil {
+		return &gol.IOError{Path: path, Err: err}
+	}
+	return nil
+}
+
+// Frames returns the number of frames captured.
+func (r *Recorder) Frames() int {
+	return r.frames
+}
+
+// Close waits for events passed to Tee to be drained and writes the GIF.
+// It returns the first failure of writing frames.
+func (r *Recorder) Close() error {
+	if r.done != nil {
+		<-r.done
+	}
+	if r.err != nil || len(r.gif.Image) == 0 {
+		return r.err
+	}
+	file, err := os.Create(r.options.Path)
+	if err != nil {
+		return &gol.IOError{Path: r.options.Path, Err: err}
+	}
+	defer file.Close()
+	if err = gif.EncodeAll(file, &r.gif); err != nil {
+		return &gol.IOError{Path: r.options.Path, Err: err}
+	}
+	return nil
+}
@@ -65,6 +65,10 @@ sdl:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.ImageOutputComplete:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.CheckpointComplete:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+			case gol.ErrorEvent:
+				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			case gol.StateChange:
 				fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 				if e.NewState == gol.Quitting {
@@ -85,6 +89,10 @@ func RunHeadless(events <-chan gol.Event) {
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), "Final Turn Complete")
 		case gol.ImageOutputComplete:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.CheckpointComplete:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
+		case gol.ErrorEvent:
+			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 		case gol.StateChange:
 			fmt.Printf("Completed Turns %-8v %v\n", event.GetCompletedTurns(), event)
 			if e.NewState == gol.Quitting {
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestSimulator tests stepping, pausing and editing a 64x64 image through the Simulator API.
+func TestSimulator(t *testing.T) {
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Threads: 4}
+	initialAlive := readAliveCells("images/64x64.pgm", p.ImageWidth, p.ImageHeight)
+	world := make([][]uint8, p.ImageHeight)
+	for y := range world {
+		world[y] = make([]uint8, p.ImageWidth)
+	}
+	for _, cell := range initialAlive {
+		world[cell.Y][cell.X] = 255
+	}
+	sim, err := gol.NewSimulator(p, world)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	t.Run("step", func(t *testing.T) {
+		for _, n := range []int{0, -1} {
+			if stepped := sim.Step(n); stepped != 0 || sim.Turn() != 0 {
+				t.Fatalf("Expected Step(%v) to evaluate no turns, stepped %v to turn %v", n, stepped, sim.Turn())
+			}
+		}
+		if n := sim.Step(100); n != 100 || sim.Turn() != 100 {
+			t.Fatalf("Expected 100 turns, stepped %v to turn %v", n, sim.Turn())
+		}
+		p := p
+		p.Turns = 100
+		alive := sim.AliveCells()
+		assertEqualBoard(t, alive, referenceTurns(initialAlive, p), p)
+		if sim.AliveCount() != len(alive) {
+			t.Errorf("Expected %v alive cells, counted %v", len(alive), sim.AliveCount())
+		}
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		if err := sim.Pause(); err != nil {
+			t.Fatal(err)
+		}
+		stepped := make(chan int)
+		go func() { stepped <- sim.Step(1) }()
+		select {
+		case <-stepped:
+			t.Fatalf("Expected Step to wait while paused")
+		case <-time.After(100 * time.Millisecond):
+		}
+		if err := sim.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		if n := <-stepped; n != 1 {
+			t.Errorf("Expected 1 turn after resuming, stepped %v", n)
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		err := sim.ClearRegion(util.Cell{X: 0, Y: 0}, util.Cell{X: p.ImageWidth, Y: p.ImageHeight})
+		if err != nil || sim.AliveCount() != 0 {
+			t.Fatalf("Expected empty world, got %v alive cells (%v)", sim.AliveCount(), err)
+		}
+		blinker := []util.Cell{{X: 1, Y: 1}, {X: 2, Y: 1}, {X: 3, Y: 1}}
+		for _, cell := range blinker {
+			sim.SetCell(cell.X, cell.Y, true)
+		}
+		sim.Step(1)
+		p := p
+		p.Turns = 1
+		assertEqualBoard(t, sim.AliveCells(), referenceTurns(blinker, p), p)
+		if snapshot := sim.Snapshot(); snapshot[0][2] != 255 || snapshot[1][1] != 0 {
+			t.Errorf("Expected snapshot of vertical blinker")
+		}
+		var inputError *gol.InputError
+		if err := sim.SetCell(p.ImageWidth, 0, true); !errors.As(err, &inputError) {
+			t.Errorf("Expected an InputError for a cell outside of world, got %v", err)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		sim.Close()
+		if n := sim.Step(1); n != 0 {
+			t.Errorf("Expected no turn after closing, stepped %v", n)
+		}
+		if err := sim.Pause(); err == nil {
+			t.Errorf("Expected pausing to fail after closing")
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestSnapshot tests a 64x64 image run writing a checkpoint every turn while evaluation continues.
+// Each checkpoint must hold the world of its own turn, and checkpoints must complete in order.
+func TestSnapshot(t *testing.T) {
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 40, Threads: 4, CheckpointInterval: 1}
+	p.Output = filepath.Join(t.TempDir(), "{turn}")
+	initialAlive := readAliveCells("images/64x64.pgm", p.ImageWidth, p.ImageHeight)
+
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var completed []gol.CheckpointComplete
+	for event := range events {
+		switch e := event.(type) {
+		case gol.CheckpointComplete:
+			completed = append(completed, e)
+		case gol.ErrorEvent:
+			t.Errorf("Unexpected failure %v", e.Err)
+		}
+	}
+	if len(completed) == 0 {
+		t.Fatalf("Expected checkpoints, got none")
+	}
+
+	last := 0
+	for _, e := range completed {
+		if e.CompletedTurns <= last {
+			t.Errorf("Expected checkpoints in order of turns, got turn %v after %v", e.CompletedTurns, last)
+		}
+		last = e.CompletedTurns
+		checkpoint, err := gol.ReadCheckpoint(e.Filename + ".checkpoint")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint.Turn != e.CompletedTurns {
+			t.Fatalf("Expected checkpoint at turn %v, got %v", e.CompletedTurns, checkpoint.Turn)
+		}
+		var cells []util.Cell
+		for i := 0; i != p.ImageWidth*p.ImageHeight; i++ {
+			if checkpoint.Pixels[i/8]&(1<<(i%8)) != 0 {
+				cells = append(cells, util.Cell{X: i % p.ImageWidth, Y: i / p.ImageWidth})
+			}
+		}
+		reference := p
+		reference.Turns = checkpoint.Turn
+		assertEqualBoard(t, cells, referenceTurns(initialAlive, reference), reference)
+	}
+}
+
+// TestSnapshotSameFile tests checkpoints of every turn written to the same file while evaluation continues.
+// Writes of the same file must complete in order, so the checkpoint of the last turn is kept.
+func TestSnapshotSameFile(t *testing.T) {
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 40, Threads: 4, CheckpointInterval: 1}
+	p.Output = filepath.Join(t.TempDir(), "last")
+
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	for event := range events {
+		if e, ok := event.(gol.ErrorEvent); ok {
+			t.Errorf("Unexpected failure %v", e.Err)
+		}
+	}
+	checkpoint, err := gol.ReadCheckpoint(p.Output + ".checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.Turn != p.Turns {
+		t.Errorf("Expected checkpoint of turn %v to be kept, got turn %v", p.Turns, checkpoint.Turn)
+	}
+}
+
+// TestAutoSnapshot tests a 64x64 image run taking automatic snapshots every 50 turns and keeping the last 3 of them.
+func TestAutoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	p := gol.Params{ImageWidth: 64, ImageHeight: 64, Turns: 500, Threads: 4, SnapshotInterval: 50, SnapshotKeep: 3}
+	p.Output = filepath.Join(dir, "{turn}")
+
+	events := make(chan gol.Event)
+	go gol.Run(p, events, nil)
+	var checkpoints []string
+	for event := range events {
+		switch e := event.(type) {
+		case gol.CheckpointComplete:
+			if e.CompletedTurns%p.SnapshotInterval != 0 {
+				t.Errorf("Expected automatic snapshots at turns due, got turn %v", e.CompletedTurns)
+			}
+			checkpoints = append(checkpoints, e.Filename+".checkpoint")
+		case gol.ErrorEvent:
+			t.Errorf("Unexpected failure %v", e.Err)
+		}
+	}
+	if len(checkpoints) <= p.SnapshotKeep {
+		t.Fatalf("Expected more than %v automatic snapshots, got %v", p.SnapshotKeep, len(checkpoints))
+	}
+
+	// Only checkpoints of the last snapshots are left
+	kept, err := filepath.Glob(filepath.Join(dir, "*.checkpoint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := checkpoints[len(checkpoints)-p.SnapshotKeep:]
+	sort.Strings(kept)
+	sort.Strings(expected)
+	if len(kept) != len(expected) {
+		t.Fatalf("Expected checkpoints %v to be kept, found %v", expected, kept)
+	}
+	for i := range kept {
+		if kept[i] != expected[i] {
+			t.Fatalf("Expected checkpoints %v to be kept, found %v", expected, kept)
+		}
+	}
+
+	// Images of removed snapshots are removed with their checkpoints
+	for _, path := range checkpoints[:len(checkpoints)-p.SnapshotKeep] {
+		if _, err := os.Stat(strings.TrimSuffix(path, ".checkpoint") + ".pgm"); !os.IsNotExist(err) {
+			t.Errorf("Expected image of removed snapshot %v to be removed", path)
+		}
+	}
+}